@@ -24,7 +24,7 @@ export type Order = {
   deliveryAddress?: string;
   payType: PaymentType;
   paymentTime?: string;
-  stripeUrl?: string;
+  paymentUrl?: string;
   status: OrderStatus;
   takeoutExpirationTime?: string;
   total: number;
@@ -35,6 +35,7 @@ export type OrdersResponse = {
   orders: Order[];
   hasMore: boolean;
   totalPages: number;
+  nextCursor?: string;
 };
 
 export const ordersApi = api.injectEndpoints({
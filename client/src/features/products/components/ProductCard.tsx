@@ -11,17 +11,22 @@ import { useAppSelector } from "@/app/hooks";
 type Props = React.HTMLAttributes<HTMLDivElement> & {
   product: Product;
   filters?: Filter[];
+  searchId?: string;
 };
 
 export const ProductCard: React.FC<Props> = ({
   product,
   className,
   filters,
+  searchId,
   ...rest
 }) => {
   const cart = useCart();
   const isInCart = !!useAppSelector(() => cart.getItem(product.id));
   const hasFilters = filters && product.filters.size !== 0;
+  const href = searchId
+    ? `/p/${product.slug}?searchId=${searchId}`
+    : `/p/${product.slug}`;
 
   return (
     <div
@@ -34,7 +39,7 @@ export const ProductCard: React.FC<Props> = ({
       {...rest}
     >
       <Link
-        to={`/p/${product.slug}`}
+        to={href}
         className="group/img peer relative block py-[50%]"
       >
         <img
@@ -53,7 +58,7 @@ export const ProductCard: React.FC<Props> = ({
         />
       </Link>
       <Link
-        to={`/p/${product.slug}`}
+        to={href}
         className={cn(
           "line-clamp-2 min-w-0 text-sm leading-tight transition-colors",
           !hasFilters && "hover:text-primary peer-hover:text-primary",
@@ -30,6 +30,8 @@ export type Product<FilterType = string> = {
   descriptionTranslations: Translation;
   slug: string;
   price: number;
+  oldPrice?: number;
+  snippet?: string;
   filters: Map<FilterType, ProductFilterVariant>;
   images: ProductImage[];
   rating: number;
@@ -49,10 +51,17 @@ export type NewProduct = {
 export type ProductsRequest = {
   categoryId?: string;
   withTranslations?: boolean;
+  withSubcategories?: boolean;
   page?: number;
+  limit?: number;
+  cursor?: string;
+  skipTotal?: boolean;
   filters?: string;
   ids?: string[];
   search?: string;
+  minPrice?: number;
+  maxPrice?: number;
+  minRating?: number;
 };
 
 export type ProductsResponse = {
@@ -60,12 +69,47 @@ export type ProductsResponse = {
   hasMore: boolean;
   totalPages: number;
   total: number;
+  nextCursor?: string;
+  fuzzy: boolean;
+  searchId?: string;
+  facets?: Record<string, number>;
+  priceRange?: { min: number; max: number };
 };
 
 export type ProductsResponseNonTransformed = ProductsResponse & {
   products: (Product & { filters: [string, ProductFilterVariant][] })[];
 };
 
+export type Suggestions = {
+  products: {
+    id: string;
+    slug: string;
+    title: string;
+    imageUrl?: string;
+    category: string;
+  }[];
+  categories: { id: string; slug: string; title: string }[];
+};
+
+export type ComparisonRow = {
+  id: string;
+  slug: string;
+  title: string;
+  values: ProductFilterVariant[][];
+  differs: boolean;
+};
+
+export type Comparison = {
+  products: Product[];
+  rows: ComparisonRow[];
+  sameCategory: boolean;
+};
+
+export type ProductBySlugRequest = {
+  slug: string;
+  searchId?: string;
+};
+
 export type ChangeProduct = WithId<Omit<NewProduct, "categoryId">>;
 
 export type ProductRoute = BreadcrumbRoute;
@@ -86,6 +130,7 @@ export type ReviewResponse = {
   reviews: Review[];
   hasMore: boolean;
   totalPages: number;
+  nextCursor?: string;
 };
 
 export type ReviewsRequest = {
@@ -123,10 +168,17 @@ export const productApi = api.injectEndpoints({
         url: `product?${args.filters ?? ""}`,
         params: {
           withTranslations: args.withTranslations,
+          withSubcategories: args.withSubcategories,
           page: args.page,
+          limit: args.limit,
+          cursor: args.cursor,
+          skipTotal: args.skipTotal,
           categoryId: args.categoryId,
           ids: args.ids?.join(","),
           q: args.search,
+          minPrice: args.minPrice,
+          maxPrice: args.maxPrice,
+          minRating: args.minRating,
         },
       }),
       transformResponse: (response: ProductsResponseNonTransformed) => {
@@ -185,10 +237,36 @@ export const productApi = api.injectEndpoints({
       Product & {
         filters: [ProductFilter, ProductFilterVariant][];
       },
-      string
+      ProductBySlugRequest
     >({
-      query: (product) => ({ url: `product/${product}` }),
-      providesTags: (_result, _error, id) => [{ type: "Products", id }],
+      query: ({ slug, searchId }) => ({
+        url: `product/${slug}`,
+        params: { searchId },
+      }),
+      providesTags: (_result, _error, { slug }) => [
+        { type: "Products", id: slug },
+      ],
+    }),
+
+    getSuggestions: builder.query<Suggestions, string>({
+      query: (q) => ({ url: `product/suggest`, params: { q } }),
+    }),
+
+    compareProducts: builder.query<Comparison, string[]>({
+      query: (ids) => ({
+        url: `product/compare`,
+        params: { ids: ids.join(",") },
+      }),
+      transformResponse: (
+        response: Omit<Comparison, "products"> &
+          Pick<ProductsResponseNonTransformed, "products">,
+      ) => {
+        const products = response.products.map((r) => ({
+          ...r,
+          filters: new Map(r.filters),
+        }));
+        return { ...response, products };
+      },
     }),
 
     getProductRoute: builder.query<ProductRoute[], string>({
@@ -275,6 +353,20 @@ export const productApi = api.injectEndpoints({
             ]
           : [{ type: "Products", id: "LIST" }],
     }),
+
+    getRelatedProducts: builder.query<Product[], string>({
+      query: (productId) => ({ url: `product/${productId}/related` }),
+      transformResponse: (
+        response: ProductsResponseNonTransformed["products"],
+      ) => response.map((p) => ({ ...p, filters: new Map(p.filters) })),
+    }),
+
+    getBoughtTogetherProducts: builder.query<Product[], string>({
+      query: (productId) => ({ url: `product/${productId}/bought-together` }),
+      transformResponse: (
+        response: ProductsResponseNonTransformed["products"],
+      ) => response.map((p) => ({ ...p, filters: new Map(p.filters) })),
+    }),
   }),
 });
 
@@ -291,4 +383,8 @@ export const {
   useChangeReviewMutation,
   useDeleteReviewMutation,
   useGetRecentProductsQuery,
+  useGetSuggestionsQuery,
+  useCompareProductsQuery,
+  useGetRelatedProductsQuery,
+  useGetBoughtTogetherProductsQuery,
 } = productApi;
@@ -53,7 +53,7 @@ export const OrdersPage = () => {
     } else if (canceled && data) {
       const payLink = data.orders.find(
         (o) => !o.paymentTime && o.payType === "pay_now",
-      )?.stripeUrl;
+      )?.paymentUrl;
       toast({
         title: t("order.pay-canceled.title"),
         description: t("order.pay-canceled.description"),
@@ -210,7 +210,7 @@ const OrderRow = ({ order: o }: { order: Order }) => {
               )}
               {canPay && (
                 <DropdownMenuItem asChild>
-                  <a href={o.stripeUrl}>{t("order.pay")}</a>
+                  <a href={o.paymentUrl}>{t("order.pay")}</a>
                 </DropdownMenuItem>
               )}
               <DropdownMenuItem className="lg:hidden">
@@ -272,7 +272,7 @@ const OrderRow = ({ order: o }: { order: Order }) => {
                   )}
                   {canPay && (
                     <Button size="sm" asChild>
-                      <a href={o.stripeUrl}>{t("order.pay")}</a>
+                      <a href={o.paymentUrl}>{t("order.pay")}</a>
                     </Button>
                   )}
                 </li>
@@ -15,6 +15,7 @@ import {
 } from "@/features/products/productsApiSlice";
 import { cn, createErrorToast, formatMoney } from "@/lib/utils";
 import { Loader2, ShoppingBag, ShoppingCart, Star } from "lucide-react";
+import { useState } from "react";
 import { useTranslation } from "react-i18next";
 import { Link, Navigate, useParams, useSearchParams } from "react-router-dom";
 
@@ -25,10 +26,13 @@ export const ProductPage = () => {
   const { slug } = useParams();
   const [searchParams, setSearchParams] = useSearchParams();
   const page = +(searchParams.get("page") ?? 1);
+  // read once, so changing the reviews page doesn't refetch the product
+  const [searchId] = useState(() => searchParams.get("searchId") ?? undefined);
 
-  const { data: product, isError } = useGetProductBySlugQuery(slug ?? "", {
-    skip: !slug,
-  });
+  const { data: product, isError } = useGetProductBySlugQuery(
+    { slug: slug ?? "", searchId },
+    { skip: !slug },
+  );
   const { data: routes } = useGetProductRouteQuery(slug ?? "", { skip: !slug });
   const { data: reviews, isFetching: isReviewsFetching } = useGetReviewsQuery(
     { productId: product?.id ?? "", page },
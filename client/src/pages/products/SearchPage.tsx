@@ -2,7 +2,7 @@ import { Pagination } from "@/components/Pagination";
 import { ProductCard } from "@/features/products/components/ProductCard";
 import { useGetProductsQuery } from "@/features/products/productsApiSlice";
 import { Loader2 } from "lucide-react";
-import { useEffect } from "react";
+import { useEffect, useState } from "react";
 import { useTranslation } from "react-i18next";
 import { useSearchParams } from "react-router-dom";
 
@@ -21,6 +21,14 @@ export const SearchPage = () => {
     { skip: !search },
   );
 
+  // only the first page is logged, the next ones keep its id for clicks
+  const [logged, setLogged] = useState<{ search: string; id: string }>();
+  useEffect(() => {
+    if (data?.searchId) setLogged({ search, id: data.searchId });
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [data?.searchId]);
+  const searchId = logged?.search === search ? logged.id : undefined;
+
   return (
     <main className="container max-w-screen-2xl space-y-8 px-2 py-8 xs:px-4">
       <h1 className="text-4xl font-extrabold tracking-tight">
@@ -30,7 +38,11 @@ export const SearchPage = () => {
         <ul className="grid grid-cols-2 border-l border-t sm:grid-cols-[repeat(auto-fill,minmax(15rem,1fr))]">
           {data?.products.map((p) => (
             <li key={p.id} className="min-w-28 border-b border-r sm:min-w-60">
-              <ProductCard product={p} className="p-2 xs:p-4" />
+              <ProductCard
+                product={p}
+                searchId={searchId}
+                className="p-2 xs:p-4"
+              />
             </li>
           ))}
         </ul>
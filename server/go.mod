@@ -36,7 +36,7 @@ require (
 	github.com/andybalholm/brotli v1.0.6 // indirect
 	github.com/georgysavva/scany/v2 v2.1.0
 	github.com/golang-jwt/jwt/v5 v5.2.0
-	github.com/google/uuid v1.5.0 // indirect
+	github.com/google/uuid v1.5.0
 	github.com/jackc/pgerrcode v0.0.0-20220416144525-469b46aa5efa
 	github.com/jackc/pgx/v5 v5.5.2
 	github.com/joho/godotenv v1.5.1
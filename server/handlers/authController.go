@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/yura4ka/vydelka/services"
@@ -28,8 +30,9 @@ func Register(c *fiber.Ctx) error {
 
 func Login(c *fiber.Ctx) error {
 	type Input struct {
-		EmailOrPhone string `json:"emailOrPhone" validate:"required" mod:"trim"`
-		Password     string `json:"password" validate:"required" mod:"trim"`
+		EmailOrPhone string          `json:"emailOrPhone" validate:"required" mod:"trim"`
+		Password     string          `json:"password" validate:"required" mod:"trim"`
+		Cart         json.RawMessage `json:"cart"`
 	}
 
 	input := new(Input)
@@ -77,6 +80,11 @@ func Login(c *fiber.Ctx) error {
 		return fiber.ErrInternalServerError
 	}
 
+	// The guest cart is a convenience, it mustn't stop the user from logging in.
+	if err := services.MergeCart(user.Id, input.Cart); err != nil {
+		log.Printf("failed to merge cart of %v: %v", user.Id, err)
+	}
+
 	var ucareToken *services.UcareToken
 	if user.IsAdmin {
 		ucareToken = services.CreateUcareToken()
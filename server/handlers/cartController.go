@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/services"
+)
+
+func GetCart(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	lang := c.Locals("lang").(services.Language)
+
+	cart, err := services.GetCart(userId, lang)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(cart)
+}
+
+func SetCartItem(c *fiber.Ctx) error {
+	input := new(services.OrderProduct)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	userId := c.Locals("userId").(string)
+	err := services.SetCartItem(userId, input)
+	if err != nil {
+		if errors.Is(err, services.ErrCartFull) || errors.Is(err, services.ErrSkuNotFound) ||
+			errors.Is(err, services.ErrUnknownProduct) {
+			return &fiber.Error{
+				Code:    400,
+				Message: err.Error(),
+			}
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func RemoveCartItem(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	userId := c.Locals("userId").(string)
+	var skuId *string
+	if sku := c.Query("skuId"); sku != "" {
+		if err := services.ValidateVar(sku, "uuid"); err != nil {
+			return fiber.ErrNotFound
+		}
+		skuId = &sku
+	}
+
+	if err := services.RemoveCartItem(userId, id, skuId); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func ClearCart(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+
+	if err := services.ClearCart(userId); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
@@ -2,15 +2,35 @@ package handlers
 
 import (
 	"errors"
-	"log"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/stripe/stripe-go/v76"
-	"github.com/stripe/stripe-go/v76/webhook"
+	"github.com/yura4ka/vydelka/payments"
 	"github.com/yura4ka/vydelka/services"
 )
 
+func createOrderError(err error) error {
+	if errors.Is(err, services.ErrOutOfStock) {
+		return &fiber.Error{
+			Code:    fiber.StatusConflict,
+			Message: err.Error(),
+		}
+	}
+	if errors.Is(err, payments.ErrUnknownProvider) ||
+		errors.Is(err, services.ErrUnknownProduct) ||
+		errors.Is(err, services.ErrEmptyCart) ||
+		errors.Is(err, services.ErrInvalidPromoCode) ||
+		errors.Is(err, services.ErrPromoCodeLimit) ||
+		errors.Is(err, services.ErrPromoCodeNotApplicable) {
+		return &fiber.Error{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+	return fiber.ErrInternalServerError
+}
+
 func CreateOrder(c *fiber.Ctx) error {
 	input := new(services.NewOrder)
 	if err := services.ValidateJSON(c, input); err != nil {
@@ -23,7 +43,27 @@ func CreateOrder(c *fiber.Ctx) error {
 
 	url, err := services.CreateOrder(input, userId, location, lang)
 	if err != nil {
-		return fiber.ErrInternalServerError
+		return createOrderError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"url": url,
+	})
+}
+
+func CheckoutCart(c *fiber.Ctx) error {
+	input := new(services.NewCartOrder)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	userId := c.Locals("userId").(string)
+	location := c.Locals("location").(string)
+	lang := c.Locals("lang").(services.Language)
+
+	url, err := services.CheckoutCart(input, userId, location, lang)
+	if err != nil {
+		return createOrderError(err)
 	}
 
 	return c.JSON(fiber.Map{
@@ -48,53 +88,233 @@ func CancelOrder(c *fiber.Ctx) error {
 
 func GetOrders(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
-	page := c.QueryInt("page", 1)
+	p, err := parsePagination(c, services.NEWEST_FIRST, 1, services.CURSOR_TIME)
+	if err != nil {
+		return err
+	}
+
+	orders, next, err := services.GetOrders(userId, p)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	response := fiber.Map{
+		"orders": orders,
+	}
+	err = pageResponse(response, p, next, func() (bool, int, error) {
+		return services.HasMoreOrders(userId, p)
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(response)
+}
+
+func parseQueryTime(c *fiber.Ctx, key string) (*time.Time, error) {
+	query := c.Query(key)
+	if query == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, query)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func parseOrdersFilter(c *fiber.Ctx) (*services.OrdersFilter, error) {
+	filter := &services.OrdersFilter{
+		UserId: c.Query("userId"),
+		Region: c.Query("region"),
+	}
+
+	if filter.UserId != "" {
+		if err := services.ValidateVar(filter.UserId, "uuid"); err != nil {
+			return nil, &fiber.Error{Code: 400, Message: "Invalid user id"}
+		}
+	}
+
+	if status := c.Query("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			err := services.ValidateVar(s, "oneof=processing confirmed received expired canceled")
+			if err != nil {
+				return nil, &fiber.Error{Code: 400, Message: "Invalid status"}
+			}
+			filter.Status = append(filter.Status, services.OrderStatus(s))
+		}
+	}
+
+	var err error
+	if filter.From, err = parseQueryTime(c, "from"); err != nil {
+		return nil, &fiber.Error{Code: 400, Message: "Invalid date"}
+	}
+	if filter.To, err = parseQueryTime(c, "to"); err != nil {
+		return nil, &fiber.Error{Code: 400, Message: "Invalid date"}
+	}
+
+	return filter, nil
+}
+
+func GetAllOrders(c *fiber.Ctx) error {
+	p, err := parsePagination(c, services.NEWEST_FIRST, 1, services.CURSOR_TIME)
+	if err != nil {
+		return err
+	}
+	filter, err := parseOrdersFilter(c)
+	if err != nil {
+		return err
+	}
+
+	orders, next, err := services.GetAllOrders(filter, p)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	response := fiber.Map{
+		"orders": orders,
+	}
+	err = pageResponse(response, p, next, func() (bool, int, error) {
+		return services.HasMoreAllOrders(filter, p)
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(response)
+}
+
+func GetOrder(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	userId := c.Locals("userId").(string)
+	isAdmin := c.Locals("isAdmin").(bool)
+	lang := c.Locals("lang").(services.Language)
+
+	var ownerId *string
+	if !isAdmin {
+		ownerId = &userId
+	}
+
+	order, err := services.GetOrderById(id, ownerId, lang)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if order == nil {
+		return fiber.ErrNotFound
+	}
+
+	return c.JSON(order)
+}
+
+func GetAdminOrder(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+	lang := c.Locals("lang").(services.Language)
+
+	order, err := services.GetOrderById(id, nil, lang)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if order == nil {
+		return fiber.ErrNotFound
+	}
+
+	return c.JSON(order)
+}
 
-	orders, err := services.GetOrders(userId, page)
+func ChangeOrderStatus(c *fiber.Ctx) error {
+	type Input struct {
+		Status services.OrderStatus `json:"status" validate:"required,oneof=confirmed received canceled expired" mod:"trim"`
+	}
+
+	input := new(Input)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	userId := c.Locals("userId").(string)
+	err := services.ChangeOrderStatus(id, input.Status, userId)
 	if err != nil {
+		if errors.Is(err, services.ErrOrderNotFound) {
+			return fiber.ErrNotFound
+		}
+		if errors.Is(err, services.ErrIllegalTransition) {
+			return &fiber.Error{
+				Code:    fiber.StatusConflict,
+				Message: err.Error(),
+			}
+		}
 		return fiber.ErrInternalServerError
 	}
 
-	hasMore, totalPages, err := services.HasMoreOrders(userId, page)
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func RefundOrder(c *fiber.Ctx) error {
+	type Input struct {
+		Lines []services.RefundLine `json:"lines" validate:"max=100,dive"`
+	}
+
+	input := new(Input)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	userId := c.Locals("userId").(string)
+	err := services.RefundOrder(id, input.Lines, userId, nil)
 	if err != nil {
+		if errors.Is(err, services.ErrOrderNotFound) {
+			return fiber.ErrNotFound
+		}
+		if errors.Is(err, services.ErrCantRefund) {
+			return &fiber.Error{
+				Code:    400,
+				Message: err.Error(),
+			}
+		}
 		return fiber.ErrInternalServerError
 	}
 
 	return c.JSON(fiber.Map{
-		"hasMore":    hasMore,
-		"totalPages": totalPages,
-		"orders":     orders,
+		"message": "Ok",
 	})
 }
 
 func HandleWebhook(c *fiber.Ctx) error {
-	payload := c.Body()
-	sigHeader := c.Get("Stripe-Signature")
-	endpointSecret := os.Getenv("STRIPE_WEBHOOK")
-	event, err := webhook.ConstructEvent(payload, sigHeader, endpointSecret)
+	provider, err := payments.Get(c.Params("provider", payments.STRIPE_PROVIDER))
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+
+	event, err := provider.VerifyWebhook(c.Body(), func(key string) string { return c.Get(key) })
 	if err != nil {
 		return fiber.ErrBadRequest
 	}
 
-	switch event.Type {
-	case stripe.EventTypeCheckoutSessionCompleted:
-		session := event.Data.Object
-		metadata := session["metadata"].(map[string]interface{})
-		orderId := metadata["orderId"].(string)
-		err := services.ConfirmOrder(orderId)
-		if err != nil {
-			return fiber.ErrInternalServerError
-		}
-	case stripe.EventTypeCheckoutSessionExpired:
-		session := event.Data.Object
-		metadata := session["metadata"].(map[string]interface{})
-		orderId := metadata["orderId"].(string)
-		err := services.ExpirePayment(orderId)
-		if err != nil {
-			return fiber.ErrInternalServerError
+	err = services.HandlePaymentEvent(provider.Name(), event)
+	if err != nil {
+		if errors.Is(err, services.ErrMalformedEvent) {
+			return &fiber.Error{
+				Code:    400,
+				Message: err.Error(),
+			}
 		}
-	default:
-		log.Printf("Unhandled event type: %v\n", event.Type)
+		return fiber.ErrInternalServerError
 	}
 
 	return c.SendStatus(200)
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/services"
+)
+
+// parsePagination reads the page, limit, cursor and skipTotal query params.
+// A cursor issued for another ordering is rejected, and without a cursor or
+// a limit the list falls back to defaultPage.
+func parsePagination(c *fiber.Ctx, orderBy string, defaultPage int, keys ...services.CursorKey) (*services.Pagination, error) {
+	limit := c.QueryInt("limit", 0)
+	if limit < 0 || limit > services.MAX_PAGE_SIZE {
+		return nil, &fiber.Error{
+			Code:    400,
+			Message: "Invalid limit",
+		}
+	}
+
+	p := &services.Pagination{
+		Page:      c.QueryInt("page", 0),
+		Limit:     limit,
+		SkipTotal: c.QueryBool("skipTotal"),
+	}
+	if p.Page < 0 {
+		return nil, &fiber.Error{
+			Code:    400,
+			Message: "Invalid page",
+		}
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := services.ParseCursor(value, orderBy, keys...)
+		if err != nil {
+			return nil, &fiber.Error{
+				Code:    400,
+				Message: "Invalid cursor",
+			}
+		}
+		p.Cursor = cursor
+		p.Page = 0
+	}
+
+	if p.Page == 0 && !p.IsKeyset() {
+		p.Page = defaultPage
+	}
+	return p, nil
+}
+
+// pageResponse adds hasMore, totalPages and, in keyset mode, nextCursor to
+// the response. count is only called when the total is needed.
+func pageResponse(response fiber.Map, p *services.Pagination, next *services.Cursor, count func() (bool, int, error)) error {
+	if p.IsKeyset() {
+		response["hasMore"] = next != nil
+		if next != nil {
+			response["nextCursor"] = next.String()
+		}
+		if p.SkipTotal {
+			return nil
+		}
+	}
+
+	hasMore, totalPages, err := count()
+	if err != nil {
+		return err
+	}
+	if !p.IsKeyset() {
+		response["hasMore"] = hasMore
+	}
+	response["totalPages"] = totalPages
+	return nil
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/yura4ka/vydelka/services"
 )
 
@@ -21,33 +26,134 @@ func GetProducts(c *fiber.Ctx) error {
 		ids = strings.Split(idsStr, ",")
 	}
 
+	statuses := make([]services.ProductStatus, 0)
+	if c.Locals("isAdmin").(bool) && c.Query("status") != "" {
+		for _, s := range strings.Split(c.Query("status"), ",") {
+			if err := services.ValidateVar(s, "oneof=draft published archived"); err != nil {
+				return &fiber.Error{
+					Code:    400,
+					Message: "Invalid status",
+				}
+			}
+			statuses = append(statuses, services.ProductStatus(s))
+		}
+	}
+
+	minPrice := c.QueryInt("minPrice", 0)
+	maxPrice := c.QueryInt("maxPrice", 0)
+	minRating := c.QueryFloat("minRating", 0)
+	if minPrice < 0 || maxPrice < 0 || (maxPrice > 0 && maxPrice < minPrice) {
+		return &fiber.Error{
+			Code:    400,
+			Message: "Invalid price range",
+		}
+	}
+	if minRating < 0 || minRating > 5 {
+		return &fiber.Error{
+			Code:    400,
+			Message: "Invalid rating",
+		}
+	}
+
+	orderBy := c.Query("orderBy")
+	if orderBy == "" && c.Query("q") != "" {
+		orderBy = "relevance"
+	}
+	if orderBy == "" || (orderBy == "relevance" && c.Query("q") == "") {
+		orderBy = "new"
+	}
+
+	pagination, err := parsePagination(c, orderBy, 0, services.ProductCursorKeys(orderBy)...)
+	if err != nil {
+		return err
+	}
+
 	request := &services.ProductsRequest{
-		CategoryId:       c.Query("categoryId"),
-		WithTranslations: c.QueryBool("withTranslations"),
-		Page:             c.QueryInt("page", 0),
-		Lang:             c.Locals("lang").(services.Language),
-		Filters:          filters,
-		OrderBy:          c.Query("orderBy", "new"),
-		Ids:              ids,
-		Search:           c.Query("q"),
+		CategoryId:        c.Query("categoryId"),
+		WithTranslations:  c.QueryBool("withTranslations"),
+		WithSubcategories: c.QueryBool("withSubcategories"),
+		Lang:              c.Locals("lang").(services.Language),
+		Filters:           filters,
+		OrderBy:           orderBy,
+		Ids:               ids,
+		Search:            c.Query("q"),
+		Statuses:          statuses,
+		MinPrice:          uint64(minPrice),
+		MaxPrice:          uint64(maxPrice),
+		MinRating:         minRating,
+		Pagination:        *pagination,
 	}
 
-	products, err := services.GetProducts(request)
+	index := services.GetSearchIndex()
+	products, next, total, err := index.Query(request)
 	if err != nil {
 		return fiber.ErrInternalServerError
 	}
 
-	total, err := services.GetTotalProducts(request)
+	response := fiber.Map{
+		"products": products,
+		"fuzzy":    request.Fuzzy,
+	}
+	if total != nil {
+		response["hasMore"] = total.HasMore
+		response["totalPages"] = total.TotalPages
+		response["total"] = total.Total
+	}
+	if request.IsKeyset() {
+		response["hasMore"] = next != nil
+		if next != nil {
+			response["nextCursor"] = next.String()
+		}
+	}
+
+	// only the first page is logged, so paging through results isn't counted as new searches
+	if request.Search != "" && request.Page <= 1 && request.Cursor == nil {
+		// without the total only an empty page is known for sure
+		results := uint64(len(products))
+		if total != nil {
+			results = total.Total
+		}
+		entry := &services.SearchLogEntry{
+			Id:      uuid.NewString(),
+			Query:   strings.Clone(request.Search),
+			Lang:    request.Lang,
+			Results: results,
+			IsFuzzy: request.Fuzzy,
+			UserId:  c.Locals("userId").(string),
+		}
+		go func() {
+			if err := services.LogSearch(entry); err != nil {
+				log.Printf("failed to log search: %v", err)
+			}
+		}()
+		response["searchId"] = entry.Id
+	}
+
+	if request.CategoryId != "" {
+		facets, err := index.Facets(request)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		response["facets"] = facets
+
+		priceRange, err := services.GetPriceRange(request)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		response["priceRange"] = priceRange
+	}
+
+	return c.JSON(response)
+}
+
+func GetSuggestions(c *fiber.Ctx) error {
+	lang := c.Locals("lang").(services.Language)
+	suggestions, err := services.GetSuggestions(c.Query("q"), lang)
 	if err != nil {
 		return fiber.ErrInternalServerError
 	}
 
-	return c.JSON(fiber.Map{
-		"products":   products,
-		"hasMore":    total.HasMore,
-		"totalPages": total.TotalPages,
-		"total":      total.Total,
-	})
+	return c.JSON(suggestions)
 }
 
 func CreateProduct(c *fiber.Ctx) error {
@@ -56,7 +162,8 @@ func CreateProduct(c *fiber.Ctx) error {
 		return err
 	}
 
-	id, err := services.CreateProduct(input)
+	adminId := c.Locals("userId").(string)
+	id, err := services.CreateProduct(input, adminId)
 	if err != nil {
 		if err := services.IsUniqueViolation(err); err != nil {
 			return err
@@ -75,7 +182,8 @@ func ChangeProduct(c *fiber.Ctx) error {
 		return err
 	}
 
-	err := services.ChangeProduct(input)
+	adminId := c.Locals("userId").(string)
+	err := services.ChangeProduct(input, adminId)
 	if err != nil {
 		if err := services.IsUniqueViolation(err); err != nil {
 			return err
@@ -88,6 +196,295 @@ func ChangeProduct(c *fiber.Ctx) error {
 	})
 }
 
+func ChangeStock(c *fiber.Ctx) error {
+	type Input struct {
+		Delta int `json:"delta" validate:"required"`
+	}
+
+	input := new(Input)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	stock, err := services.ChangeStock(id, input.Delta)
+	if err != nil {
+		if errors.Is(err, services.ErrOutOfStock) {
+			return &fiber.Error{
+				Code:    400,
+				Message: "Stock cannot be negative",
+			}
+		}
+		if errors.Is(err, services.ErrProductHasSkus) {
+			return &fiber.Error{
+				Code:    400,
+				Message: err.Error(),
+			}
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"stock": stock,
+	})
+}
+
+func skuError(err error) error {
+	if err := services.IsUniqueViolation(err); err != nil {
+		return err
+	}
+	if errors.Is(err, services.ErrInvalidSkuOptions) || errors.Is(err, services.ErrDuplicateSku) {
+		return &fiber.Error{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+	if errors.Is(err, services.ErrSkuNotFound) || errors.Is(err, services.ErrProductNotFound) {
+		return fiber.ErrNotFound
+	}
+	return fiber.ErrInternalServerError
+}
+
+func CreateSku(c *fiber.Ctx) error {
+	input := new(services.NewSku)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	skuId, err := services.CreateSku(id, input)
+	if err != nil {
+		return skuError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"id": skuId,
+	})
+}
+
+func ChangeSku(c *fiber.Ctx) error {
+	input := new(services.TChangeSku)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	err := services.ChangeSku(id, input)
+	if err != nil {
+		return skuError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func DeleteSku(c *fiber.Ctx) error {
+	id := c.Params("id")
+	skuId := c.Params("skuId")
+
+	if err := services.DeleteSku(id, skuId); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func ChangeSkuStock(c *fiber.Ctx) error {
+	type Input struct {
+		Delta int `json:"delta" validate:"required"`
+	}
+
+	input := new(Input)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	skuId := c.Params("skuId")
+	stock, err := services.ChangeSkuStock(id, skuId, input.Delta)
+	if err != nil {
+		if errors.Is(err, services.ErrOutOfStock) {
+			return &fiber.Error{
+				Code:    400,
+				Message: "Stock cannot be negative",
+			}
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"stock": stock,
+	})
+}
+
+func isCSVRequest(c *fiber.Ctx) bool {
+	return c.Query("format") == "csv" || strings.HasPrefix(c.Get(fiber.HeaderContentType), "text/csv")
+}
+
+func ImportProducts(c *fiber.Ctx) error {
+	products := make([]services.ImportProduct, 0)
+	if isCSVRequest(c) {
+		var err error
+		products, err = services.ParseProductsCSV(bytes.NewReader(c.Body()))
+		if err != nil {
+			return &fiber.Error{
+				Code:    400,
+				Message: err.Error(),
+			}
+		}
+	} else if err := c.BodyParser(&products); err != nil {
+		return &fiber.Error{
+			Code:    400,
+			Message: "Invalid body",
+		}
+	}
+
+	if len(products) == 0 || len(products) > services.MAX_IMPORT_ROWS {
+		return &fiber.Error{
+			Code:    400,
+			Message: fmt.Sprintf("Import must contain between 1 and %v products", services.MAX_IMPORT_ROWS),
+		}
+	}
+
+	adminId := c.Locals("userId").(string)
+	result, err := services.ImportProducts(products, c.QueryBool("dryRun"), adminId)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	if len(result.Errors) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(result)
+	}
+	return c.JSON(result)
+}
+
+func ExportProducts(c *fiber.Ctx) error {
+	category := c.Query("category")
+	if category == "" {
+		return &fiber.Error{
+			Code:    400,
+			Message: "Category is required",
+		}
+	}
+
+	products, err := services.ExportProducts(category)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	if c.Query("format") != "csv" {
+		return c.JSON(products)
+	}
+
+	c.Attachment(category + ".csv")
+	if err := services.WriteProductsCSV(c, products); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return nil
+}
+
+func GetPriceHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	history, err := services.GetPriceHistory(id)
+	if err != nil {
+		if errors.Is(err, services.ErrProductNotFound) {
+			return fiber.ErrNotFound
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(history)
+}
+
+func CreateSale(c *fiber.Ctx) error {
+	input := new(services.NewSale)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	adminId := c.Locals("userId").(string)
+	saleId, err := services.CreateSale(id, input, adminId)
+	if err != nil {
+		if errors.Is(err, services.ErrProductNotFound) {
+			return fiber.ErrNotFound
+		}
+		if errors.Is(err, services.ErrInvalidSalePeriod) {
+			return &fiber.Error{
+				Code:    400,
+				Message: err.Error(),
+			}
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"id": saleId,
+	})
+}
+
+func EndSale(c *fiber.Ctx) error {
+	id := c.Params("id")
+	saleId := c.Params("saleId")
+	if services.ValidateVar(id, "uuid") != nil || services.ValidateVar(saleId, "uuid") != nil {
+		return fiber.ErrNotFound
+	}
+
+	err := services.EndSale(id, saleId)
+	if err != nil {
+		if errors.Is(err, services.ErrPriceNotFound) {
+			return fiber.ErrNotFound
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func ChangeProductStatus(c *fiber.Ctx) error {
+	type Input struct {
+		Status services.ProductStatus `json:"status" validate:"required,oneof=draft published archived" mod:"trim"`
+	}
+
+	input := new(Input)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := services.ChangeProductStatus(id, input.Status); err != nil {
+		if errors.Is(err, services.ErrProductNotFound) {
+			return fiber.ErrNotFound
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
 func DeleteProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
 	if err := services.DeleteProduct(id); err != nil {
@@ -110,11 +507,46 @@ func GetPopularProducts(c *fiber.Ctx) error {
 	return c.JSON(result)
 }
 
+func CompareProducts(c *fiber.Ctx) error {
+	lang := c.Locals("lang").(services.Language)
+
+	ids := make([]string, 0)
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if services.ValidateVar(id, "uuid") != nil {
+			return &fiber.Error{
+				Code:    400,
+				Message: "Invalid product id",
+			}
+		}
+		if !services.SliceContains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < services.COMPARE_MIN_PRODUCTS || len(ids) > services.COMPARE_MAX_PRODUCTS {
+		return &fiber.Error{
+			Code:    400,
+			Message: fmt.Sprintf("Comparison must contain between %v and %v products", services.COMPARE_MIN_PRODUCTS, services.COMPARE_MAX_PRODUCTS),
+		}
+	}
+
+	comparison, err := services.CompareProducts(ids, lang)
+	if err != nil {
+		if errors.Is(err, services.ErrComparedProductNotFound) {
+			return fiber.ErrNotFound
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(comparison)
+}
+
 func GetProductBySlug(c *fiber.Ctx) error {
 	slug := c.Params("product")
 	lang := c.Locals("lang").(services.Language)
 
-	product, err := services.GetProductBySlug(slug, lang)
+	isAdmin := c.Locals("isAdmin").(bool)
+
+	product, err := services.GetProductBySlug(slug, lang, isAdmin)
 	if err != nil {
 		return fiber.ErrInternalServerError
 	}
@@ -122,6 +554,16 @@ func GetProductBySlug(c *fiber.Ctx) error {
 		return fiber.ErrNotFound
 	}
 
+	searchId := c.Query("searchId")
+	if searchId != "" && services.ValidateVar(searchId, "uuid") == nil {
+		searchId = strings.Clone(searchId)
+		go func() {
+			if err := services.LogSearchClick(searchId, product.Id); err != nil {
+				log.Printf("failed to log search click: %v", err)
+			}
+		}()
+	}
+
 	return c.JSON(product)
 }
 
@@ -129,8 +571,13 @@ func GetProductRoute(c *fiber.Ctx) error {
 	slug := c.Params("product")
 	lang := c.Locals("lang").(services.Language)
 
-	routes, err := services.GetProductRoute(slug, lang)
+	isAdmin := c.Locals("isAdmin").(bool)
+
+	routes, err := services.GetProductRoute(slug, lang, isAdmin)
 	if err != nil {
+		if errors.Is(err, services.ErrProductNotFound) {
+			return fiber.ErrNotFound
+		}
 		return fiber.ErrInternalServerError
 	}
 
@@ -139,23 +586,27 @@ func GetProductRoute(c *fiber.Ctx) error {
 
 func GetReviews(c *fiber.Ctx) error {
 	id := c.Params("id")
-	page := c.QueryInt("page", 1)
+	p, err := parsePagination(c, services.NEWEST_FIRST, 1, services.CURSOR_TIME)
+	if err != nil {
+		return err
+	}
 
-	reviews, err := services.GetReviews(id, page)
+	reviews, next, err := services.GetReviews(id, p)
 	if err != nil {
 		return fiber.ErrInternalServerError
 	}
 
-	hasMore, totalPages, err := services.HasMoreReviews(id, page)
+	response := fiber.Map{
+		"reviews": reviews,
+	}
+	err = pageResponse(response, p, next, func() (bool, int, error) {
+		return services.HasMoreReviews(id, p)
+	})
 	if err != nil {
 		return fiber.ErrInternalServerError
 	}
 
-	return c.JSON(fiber.Map{
-		"totalPages": totalPages,
-		"hasMore":    hasMore,
-		"reviews":    reviews,
-	})
+	return c.JSON(response)
 }
 
 func CreateReview(c *fiber.Ctx) error {
@@ -208,6 +659,29 @@ func DeleteReview(c *fiber.Ctx) error {
 	})
 }
 
+func getRecommendations(c *fiber.Ctx, t services.RecommendationType) error {
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+	lang := c.Locals("lang").(services.Language)
+
+	result, err := services.GetRecommendations(id, t, lang)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(result)
+}
+
+func GetRelatedProducts(c *fiber.Ctx) error {
+	return getRecommendations(c, services.RECOMMENDATION_RELATED)
+}
+
+func GetBoughtTogetherProducts(c *fiber.Ctx) error {
+	return getRecommendations(c, services.RECOMMENDATION_BOUGHT_TOGETHER)
+}
+
 func GetRecentProducts(c *fiber.Ctx) error {
 	location := c.Locals("location").(string)
 	lang := c.Locals("lang").(services.Language)
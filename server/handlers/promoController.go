@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/services"
+)
+
+func GetPromoCodes(c *fiber.Ctx) error {
+	promoCodes, err := services.GetPromoCodes()
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(promoCodes)
+}
+
+func promoCodeError(err error) error {
+	if err := services.IsUniqueViolation(err); err != nil {
+		return err
+	}
+	if errors.Is(err, services.ErrInvalidDiscount) || errors.Is(err, services.ErrInvalidPeriod) {
+		return &fiber.Error{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+	return fiber.ErrInternalServerError
+}
+
+func CreatePromoCode(c *fiber.Ctx) error {
+	input := new(services.NewPromoCode)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id, err := services.CreatePromoCode(input)
+	if err != nil {
+		return promoCodeError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"id": id,
+	})
+}
+
+func ChangePromoCode(c *fiber.Ctx) error {
+	input := new(services.TChangePromoCode)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	if err := services.ChangePromoCode(input); err != nil {
+		return promoCodeError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func DeletePromoCode(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.ValidateVar(id, "uuid"); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	if err := services.DeletePromoCode(id); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
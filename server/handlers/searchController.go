@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/services"
+)
+
+func GetSearchReport(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 {
+		return &fiber.Error{
+			Code:    400,
+			Message: "Invalid period",
+		}
+	}
+
+	from := time.Now().AddDate(0, 0, -days)
+	report, err := services.GetSearchReport(from)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(report)
+}
+
+func GetSynonyms(c *fiber.Ctx) error {
+	lang := services.Language(c.Query("lang"))
+	synonyms, err := services.GetSynonyms(lang)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(synonyms)
+}
+
+func CreateSynonyms(c *fiber.Ctx) error {
+	input := new(services.NewSynonyms)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id, err := services.CreateSynonyms(input)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"id": id,
+	})
+}
+
+func ChangeSynonyms(c *fiber.Ctx) error {
+	input := new(services.TChangeSynonyms)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	if err := services.ChangeSynonyms(input); err != nil {
+		if errors.Is(err, services.ErrSynonymsNotFound) {
+			return fiber.ErrNotFound
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func DeleteSynonyms(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.DeleteSynonyms(id); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
+
+func GetStopWords(c *fiber.Ctx) error {
+	lang := services.Language(c.Query("lang"))
+	stopWords, err := services.GetStopWords(lang)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(stopWords)
+}
+
+func CreateStopWord(c *fiber.Ctx) error {
+	input := new(services.NewStopWord)
+	if err := services.ValidateJSON(c, input); err != nil {
+		return err
+	}
+
+	id, err := services.CreateStopWord(input)
+	if err != nil {
+		if err := services.IsUniqueViolation(err); err != nil {
+			return err
+		}
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"id": id,
+	})
+}
+
+func DeleteStopWord(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := services.DeleteStopWord(id); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ok",
+	})
+}
@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/yura4ka/vydelka/db"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+var registered = make([]Job, 0)
+
+func Register(job Job) {
+	registered = append(registered, job)
+}
+
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// runLocked runs the job only if no other replica holds its advisory lock.
+func runLocked(job Job) error {
+	conn, err := db.Client.Acquire(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	key := lockKey(job.Name)
+	var locked bool
+	err = conn.QueryRow(db.Ctx, "SELECT pg_try_advisory_lock($1);", key).Scan(&locked)
+	if err != nil || !locked {
+		return err
+	}
+	defer conn.Exec(db.Ctx, "SELECT pg_advisory_unlock($1);", key)
+
+	return job.Run()
+}
+
+func run(job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := runLocked(job); err != nil {
+			log.Printf("job %s failed: %v", job.Name, err)
+		}
+		<-ticker.C
+	}
+}
+
+func Start() {
+	for _, job := range registered {
+		go run(job)
+	}
+}
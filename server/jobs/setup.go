@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/yura4ka/vydelka/services"
+)
+
+func SetupJobs() {
+	Register(Job{
+		Name:     "expireTakeoutOrders",
+		Interval: time.Minute * 15,
+		Run:      services.ExpireTakeoutOrders,
+	})
+	Register(Job{
+		Name:     "expireUnpaidOrders",
+		Interval: time.Minute * 5,
+		Run:      services.ExpireUnpaidOrders,
+	})
+	Register(Job{
+		Name:     "retryRefunds",
+		Interval: time.Minute * 5,
+		Run:      services.RetryRefunds,
+	})
+	Register(Job{
+		Name:     "recomputeRecommendations",
+		Interval: time.Hour,
+		Run:      services.RecomputeRecommendations,
+	})
+
+	Start()
+}
@@ -10,9 +10,10 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
-	"github.com/stripe/stripe-go/v76"
 	"github.com/yura4ka/vydelka/db"
+	"github.com/yura4ka/vydelka/jobs"
 	"github.com/yura4ka/vydelka/middleware"
+	"github.com/yura4ka/vydelka/payments"
 	"github.com/yura4ka/vydelka/router"
 	"github.com/yura4ka/vydelka/services"
 )
@@ -51,10 +52,12 @@ func main() {
 	}))
 	app.Use(middleware.ParseLanguage)
 
-	stripe.Key = os.Getenv("STRIPE_SECRET")
+	payments.SetupProviders()
 	db.Connect(embedMigrations)
 	router.SetupRouter(app)
 	services.SetupValidator()
+	services.SetupSearchIndex()
+	jobs.SetupJobs()
 
 	port := os.Getenv("PORT")
 	if port == "" {
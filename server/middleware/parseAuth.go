@@ -11,15 +11,18 @@ func ParseAuth(c *fiber.Ctx) error {
 	cookie := strings.Split(c.Get("Authorization"), " ")
 	if len(cookie) != 2 || cookie[0] != "Bearer" {
 		c.Locals("userId", "")
+		c.Locals("isAdmin", false)
 		return c.Next()
 	}
 
 	payload, err := services.VerifyAccessToken(cookie[1])
 	if err != nil {
 		c.Locals("userId", "")
+		c.Locals("isAdmin", false)
 		return c.Next()
 	}
 
 	c.Locals("userId", payload.Id)
+	c.Locals("isAdmin", payload.IsAdmin)
 	return c.Next()
 }
@@ -25,5 +25,6 @@ func RequireAuth(c *fiber.Ctx) error {
 	}
 
 	c.Locals("userId", payload.Id)
+	c.Locals("isAdmin", payload.IsAdmin)
 	return c.Next()
 }
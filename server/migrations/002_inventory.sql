@@ -0,0 +1,11 @@
+-- +goose Up
+
+ALTER TABLE products ADD COLUMN stock INT NOT NULL DEFAULT 0 CHECK(stock >= 0);
+
+-- products were sold without stock tracking so far, so they are out of stock
+-- until admins set the real counts, either with PATCH /product/:id/stock or
+-- with a catalog import
+
+-- +goose Down
+
+ALTER TABLE products DROP COLUMN IF EXISTS stock;
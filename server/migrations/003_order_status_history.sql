@@ -0,0 +1,25 @@
+-- +goose Up
+
+CREATE TABLE order_status_history (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  status order_status NOT NULL,
+  changed_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_order_status_history_order ON order_status_history (order_id);
+
+CREATE INDEX idx_orders_status ON orders (status);
+
+-- Earlier transitions weren't recorded, so existing orders start with their
+-- current status. Only the customer is known to have set 'processing'.
+INSERT INTO order_status_history (created_at, order_id, status, changed_by)
+SELECT created_at, id, status, CASE WHEN status = 'processing' THEN user_id END
+FROM orders;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_orders_status;
+
+DROP TABLE IF EXISTS order_status_history;
@@ -0,0 +1,61 @@
+-- +goose Up
+
+ALTER TABLE order_content
+  ADD COLUMN price DECIMAL CHECK(price > 0),
+  ADD COLUMN title JSONB,
+  ADD COLUMN image_url TEXT;
+
+UPDATE order_content AS c
+SET price = p.price,
+  title = COALESCE((
+    SELECT jsonb_object_agg(pt.lang, pt.title)
+    FROM product_translations AS pt
+    WHERE pt.product_id = p.id
+  ), '{}'),
+  image_url = (
+    SELECT pi.image_url
+    FROM product_images AS pi
+    WHERE pi.product_id = p.id
+    LIMIT 1
+  )
+FROM products AS p
+WHERE c.product_id = p.id;
+
+ALTER TABLE order_content
+  ALTER COLUMN price SET NOT NULL,
+  ALTER COLUMN title SET NOT NULL;
+
+ALTER TABLE order_content DROP CONSTRAINT order_content_pkey;
+
+ALTER TABLE order_content ADD COLUMN id UUID DEFAULT gen_random_uuid() PRIMARY KEY;
+
+ALTER TABLE order_content ALTER COLUMN product_id DROP NOT NULL;
+
+ALTER TABLE order_content ADD CONSTRAINT order_content_order_product UNIQUE (order_id, product_id);
+
+ALTER TABLE order_content
+  DROP CONSTRAINT order_content_product_id_fkey,
+  ADD CONSTRAINT order_content_product_id_fkey
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE SET NULL;
+
+-- +goose Down
+
+DELETE FROM order_content WHERE product_id IS NULL;
+
+ALTER TABLE order_content
+  DROP CONSTRAINT order_content_product_id_fkey,
+  ADD CONSTRAINT order_content_product_id_fkey
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE;
+
+ALTER TABLE order_content DROP CONSTRAINT order_content_order_product;
+
+ALTER TABLE order_content DROP COLUMN id;
+
+ALTER TABLE order_content ALTER COLUMN product_id SET NOT NULL;
+
+ALTER TABLE order_content ADD PRIMARY KEY (order_id, product_id);
+
+ALTER TABLE order_content
+  DROP COLUMN price,
+  DROP COLUMN title,
+  DROP COLUMN image_url;
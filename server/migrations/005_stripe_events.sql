@@ -0,0 +1,25 @@
+-- +goose Up
+
+CREATE TABLE stripe_events (
+  id TEXT PRIMARY KEY,
+  type TEXT NOT NULL,
+  processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE orders
+  ADD COLUMN stripe_payment_intent_id TEXT,
+  ADD COLUMN payment_error TEXT,
+  ADD COLUMN refunded_amount DECIMAL NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_orders_payment_intent ON orders (stripe_payment_intent_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_orders_payment_intent;
+
+ALTER TABLE orders
+  DROP COLUMN IF EXISTS stripe_payment_intent_id,
+  DROP COLUMN IF EXISTS payment_error,
+  DROP COLUMN IF EXISTS refunded_amount;
+
+DROP TABLE IF EXISTS stripe_events;
@@ -0,0 +1,64 @@
+-- +goose Up
+
+CREATE TYPE refund_status AS ENUM ('pending', 'succeeded', 'failed');
+
+ALTER TABLE orders
+  ADD COLUMN refund_status refund_status,
+  ADD COLUMN refunded_at TIMESTAMPTZ;
+
+ALTER TABLE order_content
+  ADD COLUMN refunded_quantity INT NOT NULL DEFAULT 0
+  CHECK(refunded_quantity >= 0 AND refunded_quantity <= quantity);
+
+-- a refund is committed here as pending before it is sent to the provider,
+-- and its lines are applied to the order once the provider accepted it.
+-- canceled_status is the order status the refund canceled, so it can be
+-- restored if the provider fails to pay out
+CREATE TABLE refunds (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  amount INT NOT NULL CHECK(amount > 0),
+  changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  status refund_status NOT NULL DEFAULT 'pending',
+  restock BOOLEAN NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  requested_at TIMESTAMPTZ,
+  canceled_status order_status
+);
+
+CREATE INDEX idx_refunds_pending ON refunds (created_at) WHERE status = 'pending';
+
+CREATE TABLE refund_lines (
+  refund_id UUID NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+  content_id UUID NOT NULL REFERENCES order_content(id) ON DELETE CASCADE,
+  count INT NOT NULL CHECK(count > 0),
+  PRIMARY KEY (refund_id, content_id)
+);
+
+ALTER TABLE orders DROP CONSTRAINT payed_not_canceled;
+
+ALTER TABLE orders ADD CONSTRAINT payed_not_canceled 
+CHECK (
+  NOT(status = 'expired' AND payment_time IS NOT NULL)
+  AND NOT(status = 'canceled' AND payment_time IS NOT NULL AND refund_status IS NULL)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS refund_lines;
+
+DROP TABLE IF EXISTS refunds;
+
+ALTER TABLE orders DROP CONSTRAINT payed_not_canceled;
+
+ALTER TABLE orders ADD CONSTRAINT payed_not_canceled 
+CHECK (NOT((status = 'expired' OR status = 'canceled') AND payment_time IS NOT NULL));
+
+ALTER TABLE order_content DROP COLUMN IF EXISTS refunded_quantity;
+
+ALTER TABLE orders
+  DROP COLUMN IF EXISTS refund_status,
+  DROP COLUMN IF EXISTS refunded_at;
+
+DROP TYPE IF EXISTS refund_status CASCADE;
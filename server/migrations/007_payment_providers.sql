@@ -0,0 +1,43 @@
+-- +goose Up
+
+ALTER TABLE orders RENAME COLUMN stripe_session_id TO payment_reference;
+
+ALTER TABLE orders RENAME COLUMN stripe_url TO payment_url;
+
+ALTER TABLE orders RENAME COLUMN stripe_payment_intent_id TO payment_transaction;
+
+ALTER TABLE orders ADD COLUMN payment_provider TEXT;
+
+UPDATE orders SET payment_provider = 'stripe' WHERE payment_reference IS NOT NULL;
+
+ALTER INDEX idx_orders_payment_intent RENAME TO idx_orders_payment_transaction;
+
+ALTER TABLE stripe_events RENAME TO payment_events;
+
+ALTER TABLE payment_events ADD COLUMN provider TEXT NOT NULL DEFAULT 'stripe';
+
+ALTER TABLE payment_events DROP CONSTRAINT stripe_events_pkey;
+
+ALTER TABLE payment_events ADD PRIMARY KEY (provider, id);
+
+-- +goose Down
+
+ALTER TABLE payment_events DROP CONSTRAINT payment_events_pkey;
+
+DELETE FROM payment_events WHERE provider != 'stripe';
+
+ALTER TABLE payment_events DROP COLUMN provider;
+
+ALTER TABLE payment_events ADD CONSTRAINT stripe_events_pkey PRIMARY KEY (id);
+
+ALTER TABLE payment_events RENAME TO stripe_events;
+
+ALTER INDEX idx_orders_payment_transaction RENAME TO idx_orders_payment_intent;
+
+ALTER TABLE orders DROP COLUMN payment_provider;
+
+ALTER TABLE orders RENAME COLUMN payment_transaction TO stripe_payment_intent_id;
+
+ALTER TABLE orders RENAME COLUMN payment_url TO stripe_url;
+
+ALTER TABLE orders RENAME COLUMN payment_reference TO stripe_session_id;
@@ -0,0 +1,13 @@
+-- +goose Up
+
+CREATE TABLE cart_items (
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  quantity INT NOT NULL CHECK(quantity > 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, product_id)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS cart_items;
@@ -0,0 +1,50 @@
+-- +goose Up
+
+CREATE TYPE discount_type AS ENUM ('percentage', 'fixed');
+
+CREATE TABLE promo_codes (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  code VARCHAR(64) NOT NULL UNIQUE,
+  discount_type discount_type NOT NULL,
+  value DECIMAL NOT NULL CHECK(value > 0),
+  min_order_total DECIMAL NOT NULL DEFAULT 0 CHECK(min_order_total >= 0),
+  starts_at TIMESTAMPTZ,
+  ends_at TIMESTAMPTZ,
+  max_uses INT CHECK(max_uses > 0),
+  max_uses_per_user INT CHECK(max_uses_per_user > 0),
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  CHECK(discount_type != 'percentage' OR value <= 100),
+  CHECK(starts_at IS NULL OR ends_at IS NULL OR starts_at < ends_at)
+);
+
+CREATE TABLE promo_code_categories (
+  promo_code_id UUID NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+  category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+  PRIMARY KEY (promo_code_id, category_id)
+);
+
+ALTER TABLE orders
+  ADD COLUMN promo_code_id UUID REFERENCES promo_codes(id) ON DELETE SET NULL,
+  ADD COLUMN promo_code VARCHAR(64),
+  ADD COLUMN discount DECIMAL NOT NULL DEFAULT 0 CHECK(discount >= 0);
+
+ALTER TABLE order_content
+  ADD COLUMN discount DECIMAL NOT NULL DEFAULT 0 CHECK(discount >= 0);
+
+CREATE INDEX orders_promo_code_id_idx ON orders(promo_code_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS orders_promo_code_id_idx;
+
+ALTER TABLE order_content DROP COLUMN IF EXISTS discount;
+
+ALTER TABLE orders
+  DROP COLUMN IF EXISTS promo_code_id,
+  DROP COLUMN IF EXISTS promo_code,
+  DROP COLUMN IF EXISTS discount;
+
+DROP TABLE IF EXISTS promo_code_categories;
+DROP TABLE IF EXISTS promo_codes;
+DROP TYPE IF EXISTS discount_type CASCADE;
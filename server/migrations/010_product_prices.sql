@@ -0,0 +1,30 @@
+-- +goose Up
+
+CREATE TABLE product_prices (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  price DECIMAL NOT NULL CHECK(price > 0),
+  is_sale BOOLEAN NOT NULL DEFAULT FALSE,
+  starts_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  ends_at TIMESTAMPTZ,
+  created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  CHECK(ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX idx_product_prices_product ON product_prices (product_id, starts_at);
+
+CREATE VIEW active_sales AS
+SELECT DISTINCT ON (product_id) product_id, price
+FROM product_prices
+WHERE is_sale AND starts_at <= NOW() AND (ends_at IS NULL OR ends_at > NOW())
+ORDER BY product_id, starts_at DESC;
+
+INSERT INTO product_prices (created_at, product_id, price, starts_at)
+SELECT created_at, id, price, created_at FROM products;
+
+-- +goose Down
+
+DROP VIEW IF EXISTS active_sales;
+
+DROP TABLE IF EXISTS product_prices;
@@ -0,0 +1,79 @@
+-- +goose Up
+
+CREATE TABLE product_skus (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  code VARCHAR(64) NOT NULL UNIQUE,
+  price DECIMAL CHECK(price > 0),
+  stock INT NOT NULL DEFAULT 0 CHECK(stock >= 0)
+);
+
+CREATE INDEX idx_product_skus_product ON product_skus (product_id);
+
+CREATE TABLE sku_options (
+  sku_id UUID NOT NULL REFERENCES product_skus(id) ON DELETE CASCADE,
+  variant_id UUID NOT NULL REFERENCES filter_variants(id) ON DELETE CASCADE,
+  PRIMARY KEY (sku_id, variant_id)
+);
+
+CREATE TABLE sku_images (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  sku_id UUID NOT NULL REFERENCES product_skus(id) ON DELETE CASCADE,
+  image_url TEXT NOT NULL,
+  width INT NOT NULL,
+  height INT NOT NULL
+);
+
+-- products.stock of a product with SKUs is the sum of its SKUs stock
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION sync_product_stock()
+RETURNS TRIGGER AS $$
+BEGIN
+  UPDATE products SET stock = (
+    SELECT COALESCE(SUM(stock), 0) FROM product_skus WHERE product_id = products.id
+  )
+  WHERE id = COALESCE(NEW.product_id, OLD.product_id);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER product_skus_stock
+AFTER INSERT OR UPDATE OF stock OR DELETE ON product_skus
+FOR EACH ROW EXECUTE FUNCTION sync_product_stock();
+
+ALTER TABLE order_content
+  ADD COLUMN sku_id UUID REFERENCES product_skus(id) ON DELETE SET NULL,
+  ADD COLUMN sku_code VARCHAR(64);
+
+ALTER TABLE order_content DROP CONSTRAINT order_content_order_product;
+
+ALTER TABLE cart_items DROP CONSTRAINT cart_items_pkey;
+
+ALTER TABLE cart_items
+  ADD COLUMN sku_id UUID REFERENCES product_skus(id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX idx_cart_items_item ON cart_items
+  (user_id, product_id, COALESCE(sku_id, '00000000-0000-0000-0000-000000000000'));
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_cart_items_item;
+
+DELETE FROM cart_items WHERE sku_id IS NOT NULL;
+
+ALTER TABLE cart_items DROP COLUMN IF EXISTS sku_id;
+
+ALTER TABLE cart_items ADD PRIMARY KEY (user_id, product_id);
+
+ALTER TABLE order_content
+  DROP COLUMN IF EXISTS sku_id,
+  DROP COLUMN IF EXISTS sku_code;
+
+ALTER TABLE order_content ADD CONSTRAINT order_content_order_product UNIQUE (order_id, product_id);
+
+DROP TRIGGER IF EXISTS product_skus_stock ON product_skus;
+DROP FUNCTION IF EXISTS sync_product_stock;
+
+DROP TABLE IF EXISTS sku_images, sku_options, product_skus;
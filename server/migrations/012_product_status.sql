@@ -0,0 +1,19 @@
+-- +goose Up
+
+CREATE TYPE product_status AS ENUM ('draft', 'published', 'archived');
+
+ALTER TABLE products
+  ADD COLUMN status product_status NOT NULL DEFAULT 'published',
+  ADD COLUMN archived_at TIMESTAMPTZ;
+
+CREATE INDEX idx_products_status ON products (status);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_products_status;
+
+ALTER TABLE products
+  DROP COLUMN IF EXISTS status,
+  DROP COLUMN IF EXISTS archived_at;
+
+DROP TYPE IF EXISTS product_status CASCADE;
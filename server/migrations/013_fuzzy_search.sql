@@ -0,0 +1,31 @@
+-- +goose Up
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Ukrainian to Latin after the 2010 national standard, so a query typed
+-- in Latin letters still matches Ukrainian titles
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION transliterate_ua(value TEXT)
+RETURNS TEXT AS $$
+  SELECT translate(
+    replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(
+      value, 'щ', 'shch'), 'ж', 'zh'), 'х', 'kh'), 'ц', 'ts'), 'ч', 'ch'), 'ш', 'sh'),
+      'є', 'ie'), 'ї', 'i'), 'й', 'i'), 'ю', 'iu'), 'я', 'ia'),
+    'абвгґдезиіклмнопрстуф''’ь', 'abvhgdezyiklmnoprstuf'
+  );
+$$ LANGUAGE sql IMMUTABLE;
+-- +goose StatementEnd
+
+ALTER TABLE product_translations
+  ADD COLUMN search_title TEXT GENERATED ALWAYS AS (lower(title) || ' ' || transliterate_ua(lower(title))) STORED;
+
+CREATE INDEX idx_product_translations_search_title ON product_translations
+  USING GIN (search_title gin_trgm_ops);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_product_translations_search_title;
+
+ALTER TABLE product_translations DROP COLUMN IF EXISTS search_title;
+
+DROP FUNCTION IF EXISTS transliterate_ua;
@@ -0,0 +1,33 @@
+-- +goose Up
+
+ALTER TABLE product_translations DROP COLUMN search;
+
+-- title matches rank above description matches
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION generate_tsvector(lang language_type, title VARCHAR(256), description TEXT)
+RETURNS tsvector AS $$
+  SELECT setweight(to_tsvector(lang::TEXT::REGCONFIG, title), 'A') ||
+    setweight(to_tsvector(lang::TEXT::REGCONFIG, description), 'B');
+$$ LANGUAGE sql IMMUTABLE;
+-- +goose StatementEnd
+
+ALTER TABLE product_translations
+  ADD COLUMN search tsvector GENERATED ALWAYS AS (generate_tsvector(lang, title, description)) STORED;
+
+CREATE INDEX product_tsv_idx ON product_translations USING GIN (search);
+
+-- +goose Down
+
+ALTER TABLE product_translations DROP COLUMN search;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION generate_tsvector(lang language_type, title VARCHAR(256), description TEXT)
+RETURNS tsvector AS $$
+  SELECT to_tsvector(lang::TEXT::REGCONFIG, title || ' ' || description);
+$$ LANGUAGE sql IMMUTABLE;
+-- +goose StatementEnd
+
+ALTER TABLE product_translations
+  ADD COLUMN search tsvector GENERATED ALWAYS AS (generate_tsvector(lang, title, description)) STORED;
+
+CREATE INDEX product_tsv_idx ON product_translations USING GIN (search);
@@ -0,0 +1,19 @@
+-- +goose Up
+
+CREATE TABLE search_queries (
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  query TEXT NOT NULL,
+  lang language_type NOT NULL,
+  results INT NOT NULL,
+  is_fuzzy BOOLEAN NOT NULL DEFAULT FALSE,
+  user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+  clicked_product_id UUID REFERENCES products(id) ON DELETE SET NULL,
+  clicked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_search_queries_created_at ON search_queries (created_at);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS search_queries;
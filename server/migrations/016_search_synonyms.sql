@@ -0,0 +1,66 @@
+-- +goose Up
+
+CREATE TABLE search_synonyms (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ,
+  version INT NOT NULL DEFAULT 1,
+  lang language_type NOT NULL,
+  terms TEXT[] NOT NULL CHECK(cardinality(terms) >= 2)
+);
+
+CREATE INDEX idx_search_synonyms_lang ON search_synonyms (lang);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON search_synonyms
+FOR EACH ROW
+EXECUTE PROCEDURE update_timestamp();
+
+CREATE TABLE search_stop_words (
+  id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  lang language_type NOT NULL,
+  word VARCHAR(64) NOT NULL,
+  UNIQUE(lang, word)
+);
+
+CREATE AGGREGATE tsquery_or_agg (tsquery) (SFUNC = tsquery_or, STYPE = tsquery);
+
+-- every term of a synonym group is replaced by all terms of the group,
+-- a stop word by nothing
+CREATE VIEW search_rewrite_rules AS
+SELECT lang, target, substitute
+FROM (
+  SELECT s.lang, plainto_tsquery(s.lang::TEXT::REGCONFIG, t.term) AS target,
+    (
+      SELECT tsquery_or_agg(plainto_tsquery(s.lang::TEXT::REGCONFIG, o.term))
+      FROM unnest(s.terms) AS o(term)
+    ) AS substitute
+  FROM search_synonyms AS s, unnest(s.terms) AS t(term)
+
+  UNION ALL
+
+  SELECT lang, plainto_tsquery(lang::TEXT::REGCONFIG, word), ''::tsquery
+  FROM search_stop_words
+) AS rules
+WHERE numnode(target) > 0;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION search_tsquery(lang language_type, query TEXT)
+RETURNS tsquery AS $$
+  SELECT ts_rewrite(
+    plainto_tsquery(lang::TEXT::REGCONFIG, query),
+    format('SELECT target, substitute FROM search_rewrite_rules WHERE lang = %L', lang)
+  );
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+
+DROP FUNCTION IF EXISTS search_tsquery;
+
+DROP VIEW IF EXISTS search_rewrite_rules;
+
+DROP AGGREGATE IF EXISTS tsquery_or_agg (tsquery);
+
+DROP TABLE IF EXISTS search_stop_words, search_synonyms;
@@ -0,0 +1,19 @@
+-- +goose Up
+
+CREATE TYPE recommendation_type AS ENUM ('related', 'bought_together');
+
+-- recomputed periodically by the recomputeRecommendations job
+CREATE TABLE product_recommendations (
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  type recommendation_type NOT NULL,
+  recommended_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  score INT NOT NULL,
+  position INT NOT NULL,
+  PRIMARY KEY (product_id, type, recommended_id)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS product_recommendations;
+
+DROP TYPE IF EXISTS recommendation_type;
@@ -0,0 +1,136 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const FAKE_PROVIDER = "fake"
+
+var ErrUnknownSession = errors.New("unknown payment session")
+
+type fakeSession struct {
+	orderId     string
+	amount      int64
+	status      Status
+	transaction string
+	refunded    uint64
+	refunds     map[string]bool
+}
+
+// FakeProvider keeps sessions in memory and never talks to the network.
+// Its webhook accepts an Event encoded as JSON, so it must only be enabled
+// in tests and local development.
+type FakeProvider struct {
+	mu       sync.Mutex
+	sessions map[string]*fakeSession
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{sessions: make(map[string]*fakeSession)}
+}
+
+func (p *FakeProvider) Name() string {
+	return FAKE_PROVIDER
+}
+
+func (p *FakeProvider) CreateSession(request *SessionRequest) (*Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var amount int64
+	for _, l := range request.Lines {
+		amount += int64(l.Price) * int64(l.Quantity)
+	}
+	amount -= int64(request.Discount)
+
+	reference := uuid.NewString()
+	p.sessions[reference] = &fakeSession{
+		orderId: request.OrderId,
+		amount:  amount,
+		status:  STATUS_PENDING,
+		refunds: make(map[string]bool),
+	}
+
+	return &Session{
+		Reference: reference,
+		Url:       os.Getenv("CLIENT_ADDR") + "/orders?fakePayment=" + reference,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (p *FakeProvider) Pay(reference string) (*Event, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[reference]
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+
+	s.status = STATUS_PAID
+	s.transaction = uuid.NewString()
+	return &Event{
+		Id:          uuid.NewString(),
+		Type:        EVENT_PAID,
+		OrderId:     s.orderId,
+		Transaction: s.transaction,
+		Amount:      s.amount,
+		Currency:    CURRENCY,
+	}, nil
+}
+
+func (p *FakeProvider) VerifyWebhook(payload []byte, header func(key string) string) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil || event.Id == "" {
+		return nil, ErrMalformedEvent
+	}
+	return &event, nil
+}
+
+func (p *FakeProvider) Refund(transaction string, amount uint64, orderId, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.sessions {
+		if s.transaction == transaction && s.orderId == orderId {
+			if !s.refunds[key] {
+				s.refunds[key] = true
+				s.refunded += amount
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrRefundRejected, ErrUnknownSession)
+}
+
+func (p *FakeProvider) GetStatus(reference string) (*PaymentStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[reference]
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+	return &PaymentStatus{Status: s.status, Transaction: s.transaction}, nil
+}
+
+func (p *FakeProvider) ExpireSession(reference string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[reference]
+	if !ok {
+		return ErrUnknownSession
+	}
+	if s.status == STATUS_PENDING {
+		s.status = STATUS_EXPIRED
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package payments
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var ErrMalformedEvent = errors.New("malformed webhook event")
+var ErrUnknownProvider = errors.New("unknown payment provider")
+var ErrRefundRejected = errors.New("refund rejected by the provider")
+
+const CURRENCY = "uah"
+
+type LineItem struct {
+	Title    string
+	ImageUrl *string
+	Price    uint64
+	Quantity int
+}
+
+type SessionRequest struct {
+	OrderId       string
+	CustomerEmail string
+	Lines         []LineItem
+	Discount      uint64
+	PromoCode     string
+	SuccessUrl    string
+	CancelUrl     string
+}
+
+type Session struct {
+	Reference string
+	Url       string
+	ExpiresAt time.Time
+}
+
+type EventType string
+
+const (
+	EVENT_PAID           EventType = "paid"
+	EVENT_EXPIRED        EventType = "expired"
+	EVENT_PAYMENT_FAILED EventType = "payment_failed"
+	EVENT_REFUNDED       EventType = "refunded"
+	EVENT_REFUND_FAILED  EventType = "refund_failed"
+	EVENT_IGNORED        EventType = "ignored"
+)
+
+type Event struct {
+	Id            string    `json:"id"`
+	Type          EventType `json:"type"`
+	OrderId       string    `json:"orderId"`
+	Transaction   string    `json:"transaction"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	FullyRefunded bool      `json:"fullyRefunded"`
+	RefundId      string    `json:"refundId"`
+	Message       string    `json:"message"`
+}
+
+type Status string
+
+const (
+	STATUS_PENDING Status = "pending"
+	STATUS_PAID    Status = "paid"
+	STATUS_EXPIRED Status = "expired"
+)
+
+type PaymentStatus struct {
+	Status      Status
+	Transaction string
+}
+
+type Provider interface {
+	Name() string
+	CreateSession(request *SessionRequest) (*Session, error)
+	VerifyWebhook(payload []byte, header func(key string) string) (*Event, error)
+	// Refund must be safe to retry with the same key, it is sent again after
+	// a failure or a crash. Errors that a retry can't fix wrap
+	// ErrRefundRejected. The key is reported back as RefundId if the refund
+	// fails later.
+	Refund(transaction string, amount uint64, orderId, key string) error
+	GetStatus(reference string) (*PaymentStatus, error)
+	// ExpireSession closes a session that hasn't been paid, so it can't be
+	// paid anymore
+	ExpireSession(reference string) error
+}
+
+var providers = make(map[string]Provider)
+var defaultProvider string
+
+func Register(p Provider) {
+	providers[p.Name()] = p
+}
+
+func Get(name string) (Provider, error) {
+	if name == "" {
+		name = defaultProvider
+	}
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+func SetupProviders() {
+	Register(NewStripeProvider(os.Getenv("STRIPE_SECRET"), os.Getenv("STRIPE_WEBHOOK")))
+
+	defaultProvider = os.Getenv("PAYMENT_PROVIDER")
+	if defaultProvider == FAKE_PROVIDER {
+		Register(NewFakeProvider())
+	}
+	if defaultProvider == "" {
+		defaultProvider = STRIPE_PROVIDER
+	}
+}
@@ -0,0 +1,218 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/checkout/session"
+	"github.com/stripe/stripe-go/v76/coupon"
+	"github.com/stripe/stripe-go/v76/refund"
+	"github.com/stripe/stripe-go/v76/webhook"
+)
+
+const STRIPE_PROVIDER = "stripe"
+
+type StripeProvider struct {
+	webhookSecret string
+}
+
+func NewStripeProvider(secret, webhookSecret string) *StripeProvider {
+	stripe.Key = secret
+	return &StripeProvider{webhookSecret}
+}
+
+func (p *StripeProvider) Name() string {
+	return STRIPE_PROVIDER
+}
+
+func (p *StripeProvider) CreateSession(request *SessionRequest) (*Session, error) {
+	lineItems := make([]*stripe.CheckoutSessionLineItemParams, len(request.Lines))
+	for i, l := range request.Lines {
+		images := make([]string, 0)
+		if l.ImageUrl != nil {
+			images = append(images, *l.ImageUrl)
+		}
+
+		lineItems[i] = &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+					Name:   stripe.String(l.Title),
+					Images: stripe.StringSlice(images),
+				},
+				UnitAmount:  stripe.Int64(int64(l.Price)),
+				Currency:    stripe.String(CURRENCY),
+				TaxBehavior: stripe.String(string(stripe.TaxCalculationLineItemTaxBehaviorExclusive)),
+			},
+			Quantity: stripe.Int64(int64(l.Quantity)),
+		}
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		CustomerEmail: &request.CustomerEmail,
+		LineItems:     lineItems,
+		Mode:          stripe.String(string(stripe.CheckoutSessionModePayment)),
+		Metadata: map[string]string{
+			"orderId": request.OrderId,
+		},
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
+			Metadata: map[string]string{
+				"orderId": request.OrderId,
+			},
+		},
+		SuccessURL: stripe.String(request.SuccessUrl),
+		CancelURL:  stripe.String(request.CancelUrl),
+	}
+
+	if request.Discount > 0 {
+		c, err := coupon.New(&stripe.CouponParams{
+			AmountOff:      stripe.Int64(int64(request.Discount)),
+			Currency:       stripe.String(CURRENCY),
+			Duration:       stripe.String(string(stripe.CouponDurationOnce)),
+			MaxRedemptions: stripe.Int64(1),
+			Name:           stripe.String(request.PromoCode),
+		})
+		if err != nil {
+			return nil, err
+		}
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(c.ID)}}
+	}
+
+	s, err := session.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Reference: s.ID,
+		Url:       s.URL,
+		ExpiresAt: time.Unix(s.ExpiresAt, 0),
+	}, nil
+}
+
+func (p *StripeProvider) VerifyWebhook(payload []byte, header func(key string) string) (*Event, error) {
+	event, err := webhook.ConstructEvent(payload, header("Stripe-Signature"), p.webhookSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Event{Id: event.ID, Type: EVENT_IGNORED}
+
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted, stripe.EventTypeCheckoutSessionAsyncPaymentSucceeded:
+		s, err := parseCheckoutSession(&event)
+		if err != nil {
+			return nil, err
+		}
+		if event.Type == stripe.EventTypeCheckoutSessionCompleted &&
+			s.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+			return result, nil
+		}
+
+		result.Type = EVENT_PAID
+		result.OrderId = s.Metadata["orderId"]
+		result.Amount = s.AmountTotal
+		result.Currency = string(s.Currency)
+		if s.PaymentIntent != nil {
+			result.Transaction = s.PaymentIntent.ID
+		}
+	case stripe.EventTypeCheckoutSessionExpired, stripe.EventTypeCheckoutSessionAsyncPaymentFailed:
+		s, err := parseCheckoutSession(&event)
+		if err != nil {
+			return nil, err
+		}
+		result.Type = EVENT_EXPIRED
+		result.OrderId = s.Metadata["orderId"]
+	case stripe.EventTypeChargeRefunded:
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil || charge.PaymentIntent == nil {
+			return nil, ErrMalformedEvent
+		}
+		result.Type = EVENT_REFUNDED
+		result.Transaction = charge.PaymentIntent.ID
+		result.Amount = charge.AmountRefunded
+		result.FullyRefunded = charge.Refunded
+	case stripe.EventTypeChargeRefundUpdated:
+		var r stripe.Refund
+		if err := json.Unmarshal(event.Data.Raw, &r); err != nil || r.PaymentIntent == nil {
+			return nil, ErrMalformedEvent
+		}
+		if r.Status == stripe.RefundStatusFailed || r.Status == stripe.RefundStatusCanceled {
+			result.Type = EVENT_REFUND_FAILED
+			result.Transaction = r.PaymentIntent.ID
+			result.RefundId = r.Metadata["refundId"]
+		}
+	case stripe.EventTypePaymentIntentPaymentFailed:
+		var intent stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &intent); err != nil || intent.Metadata["orderId"] == "" {
+			return nil, ErrMalformedEvent
+		}
+		result.Type = EVENT_PAYMENT_FAILED
+		result.OrderId = intent.Metadata["orderId"]
+		result.Transaction = intent.ID
+		result.Message = "payment failed"
+		if intent.LastPaymentError != nil && intent.LastPaymentError.Msg != "" {
+			result.Message = intent.LastPaymentError.Msg
+		}
+	default:
+		log.Printf("Unhandled event type: %v\n", event.Type)
+	}
+
+	return result, nil
+}
+
+func parseCheckoutSession(event *stripe.Event) (*stripe.CheckoutSession, error) {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil || s.Metadata["orderId"] == "" {
+		return nil, ErrMalformedEvent
+	}
+	return &s, nil
+}
+
+func (p *StripeProvider) Refund(transaction string, amount uint64, orderId, key string) error {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(transaction),
+		Amount:        stripe.Int64(int64(amount)),
+		Metadata: map[string]string{
+			"orderId":  orderId,
+			"refundId": key,
+		},
+	}
+	params.SetIdempotencyKey(key)
+	_, err := refund.New(params)
+
+	// conflicts and rate limits are worth retrying, other client errors aren't
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.HTTPStatusCode >= 400 && stripeErr.HTTPStatusCode < 500 &&
+		stripeErr.HTTPStatusCode != 409 && stripeErr.HTTPStatusCode != 429 {
+		return fmt.Errorf("%w: %v", ErrRefundRejected, err)
+	}
+	return err
+}
+
+func (p *StripeProvider) ExpireSession(reference string) error {
+	_, err := session.Expire(reference, nil)
+	return err
+}
+
+func (p *StripeProvider) GetStatus(reference string) (*PaymentStatus, error) {
+	s, err := session.Get(reference, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PaymentStatus{Status: STATUS_PENDING}
+	if s.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid {
+		result.Status = STATUS_PAID
+	} else if s.Status == stripe.CheckoutSessionStatusExpired {
+		result.Status = STATUS_EXPIRED
+	}
+	if s.PaymentIntent != nil {
+		result.Transaction = s.PaymentIntent.ID
+	}
+
+	return result, nil
+}
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/handlers"
+	"github.com/yura4ka/vydelka/middleware"
+)
+
+func addCartRouter(app *fiber.App) {
+	cart := app.Group("cart", middleware.RequireAuth)
+
+	cart.Get("/", handlers.GetCart)
+	cart.Delete("/", handlers.ClearCart)
+	cart.Put("/items", handlers.SetCartItem)
+	cart.Delete("/items/:id", handlers.RemoveCartItem)
+}
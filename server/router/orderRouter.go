@@ -11,6 +11,13 @@ func addOrderRouter(app *fiber.App) {
 
 	order.Get("/", middleware.RequireAuth, handlers.GetOrders)
 	order.Post("/", middleware.RequireAuth, middleware.ParseLocation, handlers.CreateOrder)
+	order.Post("/cart", middleware.RequireAuth, middleware.ParseLocation, handlers.CheckoutCart)
 	order.Post("/webhook", handlers.HandleWebhook)
+	order.Post("/webhook/:provider", handlers.HandleWebhook)
 	order.Patch("/:id/cancel", middleware.RequireAuth, handlers.CancelOrder)
+	order.Get("/admin", middleware.RequireAdmin, handlers.GetAllOrders)
+	order.Get("/admin/:id", middleware.RequireAdmin, handlers.GetAdminOrder)
+	order.Patch("/:id/status", middleware.RequireAdmin, handlers.ChangeOrderStatus)
+	order.Post("/:id/refund", middleware.RequireAdmin, handlers.RefundOrder)
+	order.Get("/:id", middleware.RequireAuth, handlers.GetOrder)
 }
@@ -9,14 +9,29 @@ import (
 func addProductRouter(app *fiber.App) {
 	product := app.Group("product")
 
-	product.Get("/", handlers.GetProducts)
+	product.Get("/", middleware.ParseAuth, handlers.GetProducts)
 	product.Get("/popular", handlers.GetPopularProducts)
+	product.Get("/suggest", handlers.GetSuggestions)
+	product.Get("/compare", handlers.CompareProducts)
 	product.Get("/recent", middleware.ParseLocation, handlers.GetRecentProducts)
-	product.Get("/:product", handlers.GetProductBySlug)
-	product.Get("/:product/route", handlers.GetProductRoute)
+	product.Get("/export", middleware.RequireAdmin, handlers.ExportProducts)
+	product.Post("/import", middleware.RequireAdmin, handlers.ImportProducts)
+	product.Get("/:product", middleware.ParseAuth, handlers.GetProductBySlug)
+	product.Get("/:product/route", middleware.ParseAuth, handlers.GetProductRoute)
 	product.Post("/", middleware.RequireAdmin, handlers.CreateProduct)
 	product.Put("/", middleware.RequireAdmin, handlers.ChangeProduct)
+	product.Patch("/:id/stock", middleware.RequireAdmin, handlers.ChangeStock)
+	product.Patch("/:id/status", middleware.RequireAdmin, handlers.ChangeProductStatus)
+	product.Post("/:id/skus", middleware.RequireAdmin, handlers.CreateSku)
+	product.Put("/:id/skus", middleware.RequireAdmin, handlers.ChangeSku)
+	product.Delete("/:id/skus/:skuId", middleware.RequireAdmin, handlers.DeleteSku)
+	product.Patch("/:id/skus/:skuId/stock", middleware.RequireAdmin, handlers.ChangeSkuStock)
+	product.Get("/:id/prices", middleware.RequireAdmin, handlers.GetPriceHistory)
+	product.Post("/:id/prices", middleware.RequireAdmin, handlers.CreateSale)
+	product.Delete("/:id/prices/:saleId", middleware.RequireAdmin, handlers.EndSale)
 	product.Delete("/:id", middleware.RequireAdmin, handlers.DeleteProduct)
+	product.Get("/:id/related", handlers.GetRelatedProducts)
+	product.Get("/:id/bought-together", handlers.GetBoughtTogetherProducts)
 	product.Get("/:id/reviews", handlers.GetReviews)
 	product.Post("/:id/reviews", middleware.RequireAuth, handlers.CreateReview)
 	product.Put("/:id/reviews/:reviewId", middleware.RequireAuth, handlers.ChangeReview)
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/handlers"
+	"github.com/yura4ka/vydelka/middleware"
+)
+
+func addPromoRouter(app *fiber.App) {
+	promo := app.Group("promo", middleware.RequireAdmin)
+
+	promo.Get("/", handlers.GetPromoCodes)
+	promo.Post("/", handlers.CreatePromoCode)
+	promo.Put("/", handlers.ChangePromoCode)
+	promo.Delete("/:id", handlers.DeletePromoCode)
+}
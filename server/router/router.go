@@ -9,4 +9,7 @@ func SetupRouter(app *fiber.App) {
 	addCategoryRouter(app)
 	addProductRouter(app)
 	addOrderRouter(app)
+	addCartRouter(app)
+	addPromoRouter(app)
+	addSearchRouter(app)
 }
@@ -0,0 +1,22 @@
+package router
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yura4ka/vydelka/handlers"
+	"github.com/yura4ka/vydelka/middleware"
+)
+
+func addSearchRouter(app *fiber.App) {
+	search := app.Group("search", middleware.RequireAdmin)
+
+	search.Get("/report", handlers.GetSearchReport)
+
+	search.Get("/synonyms", handlers.GetSynonyms)
+	search.Post("/synonyms", handlers.CreateSynonyms)
+	search.Put("/synonyms", handlers.ChangeSynonyms)
+	search.Delete("/synonyms/:id", handlers.DeleteSynonyms)
+
+	search.Get("/stop-words", handlers.GetStopWords)
+	search.Post("/stop-words", handlers.CreateStopWord)
+	search.Delete("/stop-words/:id", handlers.DeleteStopWord)
+}
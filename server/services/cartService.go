@@ -0,0 +1,312 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+var ErrEmptyCart = errors.New("cart is empty")
+var ErrCartFull = errors.New("too many items in cart")
+
+const MAX_CART_ITEMS = 100
+
+// NIL_UUID stands in for a missing variant in the cart items unique index.
+const NIL_UUID = "00000000-0000-0000-0000-000000000000"
+
+type CartItem struct {
+	Product   *Product `json:"product"`
+	Sku       *Sku     `json:"sku,omitempty"`
+	Count     int      `json:"count"`
+	Available bool     `json:"available"`
+}
+
+type Cart struct {
+	Items []CartItem `json:"items"`
+	Total uint64     `json:"total"`
+}
+
+func getCartProducts(userId string) ([]OrderProduct, error) {
+	products := make([]OrderProduct, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &products, `
+		SELECT product_id AS id, sku_id, quantity AS count
+		FROM cart_items
+		WHERE user_id = $1
+		ORDER BY created_at;
+	`, userId)
+	return products, err
+}
+
+func GetCart(userId string, lang Language) (*Cart, error) {
+	cartProducts, err := getCartProducts(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Cart{Items: make([]CartItem, 0, len(cartProducts))}
+	if len(cartProducts) == 0 {
+		return result, nil
+	}
+
+	ids := make([]string, len(cartProducts))
+	skuIds := make([]string, 0)
+	for i, p := range cartProducts {
+		ids[i] = p.Id
+		if p.SkuId != nil {
+			skuIds = append(skuIds, *p.SkuId)
+		}
+	}
+
+	products, _, err := GetProducts(&ProductsRequest{
+		Lang: lang,
+		Ids:  ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	productMap := make(map[string]*Product)
+	for i := range products {
+		productMap[products[i].Id] = &products[i]
+	}
+
+	skus, err := getSkusByIds(skuIds)
+	if err != nil {
+		return nil, err
+	}
+
+	skuMap := make(map[string]*Sku)
+	for i := range skus {
+		skuMap[skus[i].Id] = &skus[i]
+	}
+
+	for _, p := range cartProducts {
+		product, ok := productMap[p.Id]
+		if !ok {
+			continue
+		}
+
+		item := CartItem{Product: product, Count: p.Count}
+		price, stock := product.Price, product.Stock
+		if p.SkuId != nil {
+			if item.Sku, ok = skuMap[*p.SkuId]; !ok {
+				continue
+			}
+			price, stock = item.Sku.Price, item.Sku.Stock
+		}
+
+		item.Available = stock >= p.Count
+		result.Items = append(result.Items, item)
+		if item.Available {
+			result.Total += price * uint64(p.Count)
+		}
+	}
+
+	return result, nil
+}
+
+func isProductPublished(id string) (bool, error) {
+	var result bool
+	err := pgxscan.Get(db.Ctx, db.Client, &result, `
+		SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND status = $2);
+	`, id, PRODUCT_PUBLISHED)
+	return result, err
+}
+
+func SetCartItem(userId string, item *OrderProduct) error {
+	ok, err := isProductPublished(item.Id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnknownProduct
+	}
+
+	if item.SkuId != nil {
+		ok, err := skuBelongsTo(item.Id, *item.SkuId)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrSkuNotFound
+		}
+	}
+
+	tag, err := db.Client.Exec(db.Ctx, `
+		INSERT INTO cart_items (user_id, product_id, sku_id, quantity)
+		SELECT $1, $2, $3, $4
+		WHERE (
+			SELECT COUNT(*) FROM cart_items
+			WHERE user_id = $1 AND NOT (product_id = $2 AND sku_id IS NOT DISTINCT FROM $3)
+		) < $5
+		ON CONFLICT (user_id, product_id, COALESCE(sku_id, '`+NIL_UUID+`'))
+		DO UPDATE SET quantity = EXCLUDED.quantity;
+	`, userId, item.Id, item.SkuId, item.Count, MAX_CART_ITEMS)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrCartFull
+	}
+	return nil
+}
+
+func RemoveCartItem(userId, productId string, skuId *string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		DELETE FROM cart_items
+		WHERE user_id = $1 AND product_id = $2 AND sku_id IS NOT DISTINCT FROM $3;
+	`, userId, productId, skuId)
+	return err
+}
+
+func ClearCart(userId string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		DELETE FROM cart_items WHERE user_id = $1;
+	`, userId)
+	return err
+}
+
+// parseGuestCart keeps the well-formed items of a cart sent by a guest, up to
+// MAX_CART_ITEMS of them. Malformed items are skipped instead of failing the
+// whole cart.
+func parseGuestCart(cart json.RawMessage) []OrderProduct {
+	var rows []json.RawMessage
+	if len(cart) == 0 || json.Unmarshal(cart, &rows) != nil {
+		return nil
+	}
+
+	items := make([]OrderProduct, 0)
+	for _, row := range rows {
+		if len(items) == MAX_CART_ITEMS {
+			break
+		}
+		var p OrderProduct
+		if json.Unmarshal(row, &p) != nil {
+			continue
+		}
+		if errs := Validate(&p); len(errs) > 0 && errs[0].Error {
+			continue
+		}
+		items = append(items, p)
+	}
+	return items
+}
+
+// MergeCart adds the guest cart to the user's one, keeping the larger
+// quantity of items present in both. New items are added in the order they
+// come until the cart holds MAX_CART_ITEMS, the rest are dropped, as are
+// malformed and unpublished ones.
+func MergeCart(userId string, cart json.RawMessage) error {
+	items := parseGuestCart(cart)
+	if len(items) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]OrderProduct)
+	keys := make([]string, 0, len(items))
+	for _, p := range items {
+		current, ok := merged[p.key()]
+		if !ok {
+			keys = append(keys, p.key())
+		}
+		if !ok || p.Count > current.Count {
+			merged[p.key()] = p
+		}
+	}
+
+	ids := make([]string, 0, len(merged))
+	skuIds := make([]*string, 0, len(merged))
+	counts := make([]int, 0, len(merged))
+	for _, key := range keys {
+		p := merged[key]
+		ids = append(ids, p.Id)
+		skuIds = append(skuIds, p.SkuId)
+		counts = append(counts, p.Count)
+	}
+
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	_, err = tx.Exec(db.Ctx, `
+		UPDATE cart_items AS c SET quantity = GREATEST(c.quantity, r.quantity)
+		FROM (
+			SELECT UNNEST($2::uuid[]) AS id, UNNEST($3::uuid[]) AS sku_id, UNNEST($4::int[]) AS quantity
+		) AS r
+		WHERE c.user_id = $1 AND c.product_id = r.id AND c.sku_id IS NOT DISTINCT FROM r.sku_id;
+	`, userId, ids, skuIds, counts)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		INSERT INTO cart_items (user_id, product_id, sku_id, quantity)
+		SELECT $1, p.id, ps.id, r.quantity
+		FROM UNNEST($2::uuid[], $3::uuid[], $4::int[]) WITH ORDINALITY AS r(id, sku_id, quantity, n)
+		INNER JOIN products AS p ON r.id = p.id
+		LEFT JOIN product_skus AS ps ON r.sku_id = ps.id AND ps.product_id = p.id
+		WHERE r.quantity > 0 AND p.status = $6 AND (r.sku_id IS NULL OR ps.id IS NOT NULL)
+			AND NOT EXISTS(
+				SELECT 1 FROM cart_items AS c
+				WHERE c.user_id = $1 AND c.product_id = r.id AND c.sku_id IS NOT DISTINCT FROM r.sku_id
+			)
+		ORDER BY r.n
+		LIMIT GREATEST($5 - (SELECT COUNT(*) FROM cart_items WHERE user_id = $1), 0)
+		ON CONFLICT (user_id, product_id, COALESCE(sku_id, '`+NIL_UUID+`')) DO NOTHING;
+	`, userId, ids, skuIds, counts, MAX_CART_ITEMS, PRODUCT_PUBLISHED)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+type NewCartOrder struct {
+	DeliveryType DeliveryType `json:"deliveryType" validate:"required" mod:"trim"`
+	Address      *string      `json:"address" validate:"required_if=DeliveryType delivery" mod:"trim"`
+	PaymentType  PayType      `json:"paymentType" validate:"required" mod:"trim"`
+	Provider     string       `json:"paymentProvider" mod:"trim"`
+	PromoCode    string       `json:"promoCode" validate:"max=64" mod:"trim,ucase"`
+}
+
+func CheckoutCart(request *NewCartOrder, userId, location string, lang Language) (string, error) {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	products := make([]OrderProduct, 0)
+	err = pgxscan.Select(db.Ctx, tx, &products, `
+		DELETE FROM cart_items
+		WHERE user_id = $1
+		RETURNING product_id AS id, sku_id, quantity AS count;
+	`, userId)
+	if err != nil {
+		return "", err
+	}
+	if len(products) == 0 {
+		return "", ErrEmptyCart
+	}
+
+	order := &NewOrder{
+		DeliveryType: request.DeliveryType,
+		Address:      request.Address,
+		PaymentType:  request.PaymentType,
+		Provider:     request.Provider,
+		PromoCode:    request.PromoCode,
+		Products:     products,
+	}
+
+	url, err := createOrder(&tx, order, userId, location, lang)
+	if err != nil {
+		return "", err
+	}
+
+	return url, tx.Commit(db.Ctx)
+}
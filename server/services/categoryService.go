@@ -171,6 +171,8 @@ type Filter struct {
 	Variants     []*FilterVariant `json:"variants"`
 }
 
+// GetFilters returns the filters of a category together with the ones it
+// inherits from its ancestors.
 func GetFilters(categoryId string, withTranslations bool, lang Language) ([]*Filter, error) {
 	result := make([]*Filter, 0)
 
@@ -192,7 +194,20 @@ func GetFilters(categoryId string, withTranslations bool, lang Language) ([]*Fil
 		{{if not .}}
 			AND vt.lang = $2 
 		{{end}}
-		WHERE f.category_id = $1
+		WHERE f.category_id IN (
+			WITH RECURSIVE CategoryAncestors AS (
+				SELECT c.id, c.parent_id
+				FROM categories AS c
+				WHERE c.id = $1
+
+				UNION ALL
+
+				SELECT c.id, c.parent_id
+				FROM categories AS c
+				JOIN CategoryAncestors AS ca ON c.id = ca.parent_id
+			)
+			SELECT id FROM CategoryAncestors
+		)
 	`))
 
 	query := ExecuteTemplate(tmpl, withTranslations)
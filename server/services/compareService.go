@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+const COMPARE_MIN_PRODUCTS = 2
+const COMPARE_MAX_PRODUCTS = 4
+
+var ErrComparedProductNotFound = errors.New("compared product not found")
+
+type ComparisonRow struct {
+	Id    string  `json:"id"`
+	Slug  string  `json:"slug"`
+	Title *string `json:"title"`
+	// Values has the variants of every product in the order of the products,
+	// empty if a product doesn't have the attribute
+	Values  [][]*FilterVariant `json:"values"`
+	Differs bool               `json:"differs"`
+}
+
+type Comparison struct {
+	Products     []Product       `json:"products"`
+	Rows         []ComparisonRow `json:"rows"`
+	SameCategory bool            `json:"sameCategory"`
+}
+
+// CompareProducts aligns the published products with the given ids by the
+// filters of their categories. Products from different categories are
+// compared by the union of the filters, so rows some of them can't have are
+// left empty for those.
+func CompareProducts(ids []string, lang Language) (*Comparison, error) {
+	var categories []struct {
+		Id         string
+		CategoryId string
+	}
+	err := pgxscan.Select(db.Ctx, db.Client, &categories, `
+		SELECT id, category_id FROM products
+		WHERE id = ANY($1) AND status = $2;
+	`, ids, PRODUCT_PUBLISHED)
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) != len(ids) {
+		return nil, ErrComparedProductNotFound
+	}
+
+	result := &Comparison{
+		Rows:         make([]ComparisonRow, 0),
+		SameCategory: true,
+	}
+
+	filters := make([]*Filter, 0)
+	seenCategories := make(map[string]bool)
+	seenFilters := make(map[string]bool)
+	for _, c := range categories {
+		if seenCategories[c.CategoryId] {
+			continue
+		}
+		if len(seenCategories) > 0 {
+			result.SameCategory = false
+		}
+		seenCategories[c.CategoryId] = true
+
+		categoryFilters, err := GetFilters(c.CategoryId, false, lang)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range categoryFilters {
+			if !seenFilters[f.Id] {
+				seenFilters[f.Id] = true
+				filters = append(filters, f)
+			}
+		}
+	}
+
+	var productVariants []struct {
+		ProductId string
+		FilterId  string
+		VariantId string
+	}
+	err = pgxscan.Select(db.Ctx, db.Client, &productVariants, `
+		SELECT pf.product_id, fv.filter_id, fv.id AS variant_id
+		FROM product_filters AS pf
+		INNER JOIN filter_variants AS fv ON pf.variant_id = fv.id
+		WHERE pf.product_id = ANY($1);
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	position := make(map[string]int, len(ids))
+	for k, id := range ids {
+		position[id] = k
+	}
+
+	selected := make(map[string][]map[string]bool)
+	for _, f := range filters {
+		selected[f.Id] = make([]map[string]bool, len(ids))
+		for k := range ids {
+			selected[f.Id][k] = make(map[string]bool)
+		}
+	}
+	for _, v := range productVariants {
+		if values, ok := selected[v.FilterId]; ok {
+			values[position[v.ProductId]][v.VariantId] = true
+		}
+	}
+
+	for _, f := range filters {
+		row := ComparisonRow{
+			Id:     f.Id,
+			Slug:   f.Slug,
+			Title:  f.Title,
+			Values: make([][]*FilterVariant, len(ids)),
+		}
+
+		keys := make([]string, len(ids))
+		isEmpty := true
+		for k := range ids {
+			row.Values[k] = make([]*FilterVariant, 0)
+			for _, v := range f.Variants {
+				if selected[f.Id][k][v.Id] {
+					row.Values[k] = append(row.Values[k], v)
+				}
+			}
+
+			variantIds := make([]string, len(row.Values[k]))
+			for i, v := range row.Values[k] {
+				variantIds[i] = v.Id
+			}
+			sort.Strings(variantIds)
+			keys[k] = strings.Join(variantIds, ",")
+			isEmpty = isEmpty && len(variantIds) == 0
+		}
+		// nothing to compare if none of the products has the attribute
+		if isEmpty {
+			continue
+		}
+
+		for _, key := range keys[1:] {
+			if key != keys[0] {
+				row.Differs = true
+				break
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	products, _, err := GetProducts(&ProductsRequest{
+		Lang: lang,
+		Ids:  ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortProductsByIds(products, ids)
+	result.Products = products
+
+	return result, nil
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+)
+
+var ErrInvalidCSV = errors.New("invalid csv")
+
+const MAX_IMPORT_ROWS = 5000
+
+var IMPORT_CSV_COLUMNS = []string{
+	"slug", "category", "price", "stock", "title_en", "title_uk",
+	"description_en", "description_uk", "filters", "images",
+}
+
+type ImportImage struct {
+	Url    string `json:"url" validate:"required,url" mod:"trim"`
+	Width  int    `json:"width" validate:"required,min=200"`
+	Height int    `json:"height" validate:"required,min=200"`
+}
+
+// ImportProduct is a single row of a catalog import or export. Filters map
+// filter slugs to variant slugs of the product category.
+type ImportProduct struct {
+	Slug        string              `json:"slug" validate:"required,max=256" mod:"trim"`
+	Category    string              `json:"category" validate:"required" mod:"trim"`
+	Price       uint64              `json:"price" validate:"required,min=1"`
+	Stock       *int                `json:"stock" validate:"omitempty,min=0"`
+	Title       Translations        `json:"title" validate:"required"`
+	Description Translations        `json:"description" validate:"required"`
+	Filters     map[string][]string `json:"filters"`
+	Images      []ImportImage       `json:"images" validate:"required,min=1,dive"`
+}
+
+type ImportRowError struct {
+	Row    int      `json:"row"`
+	Slug   string   `json:"slug,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+type ImportResult struct {
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	DryRun  bool             `json:"dryRun"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// ParseProductsCSV reads rows with the IMPORT_CSV_COLUMNS header. Filters are
+// written as "filter:variant" pairs and images as "url WIDTHxHEIGHT", both
+// separated by semicolons.
+func ParseProductsCSV(r io.Reader) ([]ImportProduct, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrInvalidCSV
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range IMPORT_CSV_COLUMNS {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %s", ErrInvalidCSV, name)
+		}
+	}
+
+	result := make([]ImportProduct, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+		}
+
+		get := func(name string) string {
+			return record[columns[name]]
+		}
+
+		p := ImportProduct{
+			Slug:        get("slug"),
+			Category:    get("category"),
+			Title:       Translations{En: get("title_en"), Ua: get("title_uk")},
+			Description: Translations{En: get("description_en"), Ua: get("description_uk")},
+			Filters:     make(map[string][]string),
+			Images:      make([]ImportImage, 0),
+		}
+		// malformed numbers are left as zero and reported by the validator
+		p.Price, _ = strconv.ParseUint(strings.TrimSpace(get("price")), 10, 64)
+		// an empty stock keeps the current one of an existing product, a
+		// malformed one becomes negative so the validator reports it
+		if stock := strings.TrimSpace(get("stock")); stock != "" {
+			n, err := strconv.Atoi(stock)
+			if err != nil {
+				n = -1
+			}
+			p.Stock = &n
+		}
+
+		for _, pair := range splitCSVList(get("filters")) {
+			filter, variant, _ := strings.Cut(pair, ":")
+			filter = strings.TrimSpace(filter)
+			p.Filters[filter] = append(p.Filters[filter], strings.TrimSpace(variant))
+		}
+
+		for _, image := range splitCSVList(get("images")) {
+			var i ImportImage
+			url, size, _ := strings.Cut(image, " ")
+			i.Url = url
+			fmt.Sscanf(strings.TrimSpace(size), "%dx%d", &i.Width, &i.Height)
+			p.Images = append(p.Images, i)
+		}
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+func splitCSVList(value string) []string {
+	result := make([]string, 0)
+	for _, v := range strings.Split(value, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func WriteProductsCSV(w io.Writer, products []ImportProduct) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(IMPORT_CSV_COLUMNS); err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		filters := make([]string, 0)
+		for filter, variants := range p.Filters {
+			for _, v := range variants {
+				filters = append(filters, filter+":"+v)
+			}
+		}
+		sort.Strings(filters)
+
+		images := make([]string, len(p.Images))
+		for i, image := range p.Images {
+			images[i] = fmt.Sprintf("%s %dx%d", image.Url, image.Width, image.Height)
+		}
+
+		stock := ""
+		if p.Stock != nil {
+			stock = strconv.Itoa(*p.Stock)
+		}
+
+		err := writer.Write([]string{
+			p.Slug, p.Category, strconv.FormatUint(p.Price, 10), stock,
+			p.Title.En, p.Title.Ua, p.Description.En, p.Description.Ua,
+			strings.Join(filters, ";"), strings.Join(images, ";"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type importLookups struct {
+	categories map[string]string
+	variants   map[string]string
+	products   map[string]string
+}
+
+func loadImportLookups(products []ImportProduct) (*importLookups, error) {
+	categorySlugs := make([]string, 0)
+	productSlugs := make([]string, 0)
+	for _, p := range products {
+		categorySlugs = append(categorySlugs, p.Category)
+		productSlugs = append(productSlugs, p.Slug)
+	}
+
+	var categories []struct{ Id, Slug string }
+	err := pgxscan.Select(db.Ctx, db.Client, &categories, `
+		SELECT id, slug FROM categories
+		WHERE slug = ANY($1);
+	`, categorySlugs)
+	if err != nil {
+		return nil, err
+	}
+
+	var variants []struct{ Id, Category, Filter, Variant string }
+	err = pgxscan.Select(db.Ctx, db.Client, &variants, `
+		WITH RECURSIVE tree AS (
+			SELECT id, parent_id, slug AS category
+			FROM categories
+			WHERE slug = ANY($1)
+
+			UNION ALL
+
+			SELECT c.id, c.parent_id, t.category
+			FROM categories AS c
+			JOIN tree AS t ON c.id = t.parent_id
+		)
+		SELECT fv.id, t.category, f.slug AS filter, fv.slug AS variant
+		FROM filter_variants AS fv
+		INNER JOIN filters AS f ON fv.filter_id = f.id
+		INNER JOIN tree AS t ON f.category_id = t.id;
+	`, categorySlugs)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []struct{ Id, Slug string }
+	err = pgxscan.Select(db.Ctx, db.Client, &existing, `
+		SELECT id, slug FROM products
+		WHERE slug = ANY($1);
+	`, productSlugs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &importLookups{
+		categories: make(map[string]string),
+		variants:   make(map[string]string),
+		products:   make(map[string]string),
+	}
+	for _, c := range categories {
+		result.categories[c.Slug] = c.Id
+	}
+	for _, v := range variants {
+		result.variants[v.Category+"/"+v.Filter+"/"+v.Variant] = v.Id
+	}
+	for _, p := range existing {
+		result.products[p.Slug] = p.Id
+	}
+	return result, nil
+}
+
+// toMutation resolves the slugs of an import row into the ids used by
+// createProduct and changeProduct.
+func (l *importLookups) toMutation(p *ImportProduct) (*baseProductMutation, []string) {
+	errs := make([]string, 0)
+	if _, ok := l.categories[p.Category]; !ok {
+		errs = append(errs, fmt.Sprintf("unknown category '%s'", p.Category))
+	}
+
+	filters := make([]string, 0)
+	for filter, variants := range p.Filters {
+		for _, v := range variants {
+			id, ok := l.variants[p.Category+"/"+filter+"/"+v]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("unknown filter variant '%s:%s'", filter, v))
+				continue
+			}
+			filters = append(filters, id)
+		}
+	}
+
+	images := make([]ProductImage, len(p.Images))
+	for i, image := range p.Images {
+		images[i] = ProductImage{uuid.NewString(), image.Url, image.Width, image.Height}
+	}
+
+	return &baseProductMutation{
+		Slug:        p.Slug,
+		Price:       p.Price,
+		Stock:       p.Stock,
+		Title:       p.Title,
+		Description: p.Description,
+		Filters:     filters,
+		Images:      images,
+	}, errs
+}
+
+// ImportProducts creates new products and updates existing ones matched by
+// slug, moving them to the category of the row if it differs. Everything runs
+// in one transaction that is committed only if every row succeeds and dryRun
+// is false.
+func ImportProducts(products []ImportProduct, dryRun bool, adminId string) (*ImportResult, error) {
+	result := &ImportResult{DryRun: dryRun, Errors: make([]ImportRowError, 0)}
+
+	// validation trims the slugs, so it has to run before the lookups
+	invalid := make(map[int][]string)
+	for i := range products {
+		if errs := Validate(&products[i]); len(errs) > 0 && errs[0].Error {
+			invalid[i] = ValidationMessages(errs)
+		}
+	}
+
+	lookups, err := loadImportLookups(products)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	seen := make(map[string]int)
+	for i := range products {
+		p := &products[i]
+		row := i + 1
+
+		if errs, ok := invalid[i]; ok {
+			result.Errors = append(result.Errors, ImportRowError{row, p.Slug, errs})
+			continue
+		}
+
+		if first, ok := seen[p.Slug]; ok {
+			message := fmt.Sprintf("duplicates row %v", first)
+			result.Errors = append(result.Errors, ImportRowError{row, p.Slug, []string{message}})
+			continue
+		}
+		seen[p.Slug] = row
+
+		mutation, errs := lookups.toMutation(p)
+		if len(errs) > 0 {
+			result.Errors = append(result.Errors, ImportRowError{row, p.Slug, errs})
+			continue
+		}
+
+		id := lookups.products[p.Slug]
+		if err := importProduct(&tx, id, lookups.categories[p.Category], mutation, adminId); err != nil {
+			message := err.Error()
+			if fiberErr := IsUniqueViolation(err); fiberErr != nil {
+				message = fiberErr.Message
+			}
+			result.Errors = append(result.Errors, ImportRowError{row, p.Slug, []string{message}})
+			continue
+		}
+
+		if id != "" {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	if err := tx.Commit(db.Ctx); err != nil {
+		return nil, err
+	}
+
+	if err := searchIndex.Rebuild(); err != nil {
+		log.Printf("failed to rebuild %s search index: %v", searchIndex.Name(), err)
+	}
+	return result, nil
+}
+
+// importProduct updates the product with the given id or creates a new one if
+// id is empty. It runs inside a savepoint, so a failed row doesn't abort the
+// whole transaction and the remaining rows still get reported.
+func importProduct(tx *pgx.Tx, id, categoryId string, p *baseProductMutation, adminId string) error {
+	sp, err := (*tx).Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer sp.Rollback(db.Ctx)
+
+	if id != "" {
+		err = changeProduct(&sp, &TChangeProduct{*p, id}, adminId)
+		if err == nil {
+			_, err = sp.Exec(db.Ctx, `
+				UPDATE products SET category_id = $1
+				WHERE id = $2 AND category_id <> $1;
+			`, categoryId, id)
+		}
+	} else {
+		_, err = createProduct(&sp, &NewProduct{baseProductMutation: *p, CategoryId: categoryId}, adminId)
+	}
+	if err != nil {
+		return err
+	}
+
+	return sp.Commit(db.Ctx)
+}
+
+func ExportProducts(category string) ([]ImportProduct, error) {
+	result := make([]ImportProduct, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT p.slug, c.slug AS category, p.price, p.stock,
+			json_build_object('en', en.title, 'uk', ua.title) AS title,
+			json_build_object('en', en.description, 'uk', ua.description) AS description,
+			COALESCE((
+				SELECT json_object_agg(x.filter, x.variants)
+				FROM (
+					SELECT f.slug AS filter, json_agg(fv.slug ORDER BY fv.slug) AS variants
+					FROM product_filters AS pf
+					INNER JOIN filter_variants AS fv ON pf.variant_id = fv.id
+					INNER JOIN filters AS f ON fv.filter_id = f.id
+					WHERE pf.product_id = p.id
+					GROUP BY f.slug
+				) AS x
+			), '{}') AS filters,
+			COALESCE((
+				SELECT json_agg(json_build_object('url', pi.image_url, 'width', pi.width, 'height', pi.height))
+				FROM product_images AS pi
+				WHERE pi.product_id = p.id
+			), '[]') AS images
+		FROM products AS p
+		INNER JOIN categories AS c ON p.category_id = c.id
+		LEFT JOIN product_translations AS en ON p.id = en.product_id AND en.lang = $2
+		LEFT JOIN product_translations AS ua ON p.id = ua.product_id AND ua.lang = $3
+		WHERE c.slug = $1
+		ORDER BY p.created_at;
+	`, category, Languages.En, Languages.Ua)
+	return result, err
+}
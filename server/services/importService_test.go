@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const importHeader = "slug,category,price,stock,title_en,title_uk,description_en,description_uk,filters,images\n"
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestParseProductsCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want ImportProduct
+	}{
+		{
+			name: "full row",
+			csv: importHeader + `phone-x,phones,19999,5,Phone,Телефон,A phone,Телефон,` +
+				`"color:black; color:white;memory:128",https://cdn.test/a.png 800x600;https://cdn.test/b.png 400x400`,
+			want: ImportProduct{
+				Slug: "phone-x", Category: "phones", Price: 19999, Stock: intPtr(5),
+				Title:       Translations{En: "Phone", Ua: "Телефон"},
+				Description: Translations{En: "A phone", Ua: "Телефон"},
+				Filters:     map[string][]string{"color": {"black", "white"}, "memory": {"128"}},
+				Images: []ImportImage{
+					{Url: "https://cdn.test/a.png", Width: 800, Height: 600},
+					{Url: "https://cdn.test/b.png", Width: 400, Height: 400},
+				},
+			},
+		},
+		{
+			name: "empty stock and lists",
+			csv:  importHeader + "case,phones,500,,Case,Чохол,,,,",
+			want: ImportProduct{
+				Slug: "case", Category: "phones", Price: 500,
+				Title:   Translations{En: "Case", Ua: "Чохол"},
+				Filters: map[string][]string{},
+				Images:  []ImportImage{},
+			},
+		},
+		{
+			name: "malformed numbers",
+			csv:  importHeader + "case,phones,cheap,many,Case,Чохол,,,,",
+			want: ImportProduct{
+				Slug: "case", Category: "phones", Price: 0, Stock: intPtr(-1),
+				Title:   Translations{En: "Case", Ua: "Чохол"},
+				Filters: map[string][]string{},
+				Images:  []ImportImage{},
+			},
+		},
+		{
+			name: "columns in another order",
+			csv:  "images,filters,description_uk,description_en,title_uk,title_en,stock,price,category,slug\n,,,,Чохол,Case,0,500,phones,case",
+			want: ImportProduct{
+				Slug: "case", Category: "phones", Price: 500, Stock: intPtr(0),
+				Title:   Translations{En: "Case", Ua: "Чохол"},
+				Filters: map[string][]string{},
+				Images:  []ImportImage{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseProductsCSV(strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatalf("ParseProductsCSV() error = %v", err)
+			}
+			if len(got) != 1 || !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("ParseProductsCSV() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProductsCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"empty", ""},
+		{"missing column", "slug,category,price\nphone,phones,100"},
+		{"short row", importHeader + "phone,phones,100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseProductsCSV(strings.NewReader(tt.csv)); !errors.Is(err, ErrInvalidCSV) {
+				t.Errorf("ParseProductsCSV() error = %v, want %v", err, ErrInvalidCSV)
+			}
+		})
+	}
+}
+
+func TestSplitCSVList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{" a ; b;;c ;", []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitCSVList(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCSVList(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+type indexedVariant struct {
+	Id     string
+	Slug   string
+	Filter string
+}
+
+type indexedText struct {
+	Title       string
+	Description string
+}
+
+type indexedProduct struct {
+	Id         string
+	CategoryId string
+	CreatedAt  time.Time
+	Status     ProductStatus
+	Price      uint64
+	Rating     float64
+	Reviews    uint64
+	Variants   []indexedVariant
+	Texts      []indexedText
+
+	titleTerms       []string
+	descriptionTerms []string
+}
+
+// MemorySearchIndex keeps the searchable fields of every product in memory
+// and only goes to the database to load the products of the requested page.
+// Words are matched by prefix in any language; synonyms, stop words and
+// snippets are features of the Postgres index only.
+type MemorySearchIndex struct {
+	mu         sync.RWMutex
+	products   map[string]*indexedProduct
+	categories map[string]*string
+}
+
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{
+		products:   make(map[string]*indexedProduct),
+		categories: make(map[string]*string),
+	}
+}
+
+func (i *MemorySearchIndex) Name() string {
+	return MEMORY_SEARCH_INDEX
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// loadIndexedProducts loads the products with the given ids, or all of them
+// if ids is nil.
+func loadIndexedProducts(ids []string) ([]*indexedProduct, error) {
+	result := make([]*indexedProduct, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT p.id, p.category_id, p.created_at, p.status, COALESCE(s.price, p.price) AS price,
+			COALESCE(r.rating, 0) AS rating, r.cnt AS reviews,
+			COALESCE((
+				SELECT json_agg(jsonb_build_object('id', fv.id, 'slug', fv.slug, 'filter', f.slug))
+				FROM product_filters AS pf
+				INNER JOIN filter_variants AS fv ON pf.variant_id = fv.id
+				INNER JOIN filters AS f ON fv.filter_id = f.id
+				WHERE pf.product_id = p.id
+			), '[]') AS variants,
+			COALESCE((
+				SELECT json_agg(jsonb_build_object('title', pt.title, 'description', pt.description))
+				FROM product_translations AS pt
+				WHERE pt.product_id = p.id
+			), '[]') AS texts
+		FROM products AS p
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS cnt, AVG(rating) AS rating
+			FROM reviews
+			WHERE product_id = p.id
+		) r ON TRUE
+		WHERE $1::uuid[] IS NULL OR p.id = ANY($1::uuid[]);
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range result {
+		for _, t := range p.Texts {
+			p.titleTerms = append(p.titleTerms, tokenize(t.Title)...)
+			p.descriptionTerms = append(p.descriptionTerms, tokenize(t.Description)...)
+		}
+	}
+	return result, nil
+}
+
+func loadCategoryParents() (map[string]*string, error) {
+	var categories []struct {
+		Id       string
+		ParentId *string
+	}
+	err := pgxscan.Select(db.Ctx, db.Client, &categories, `
+		SELECT id, parent_id FROM categories;
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*string, len(categories))
+	for _, c := range categories {
+		result[c.Id] = c.ParentId
+	}
+	return result, nil
+}
+
+func (i *MemorySearchIndex) Rebuild() error {
+	products, err := loadIndexedProducts(nil)
+	if err != nil {
+		return err
+	}
+
+	categories, err := loadCategoryParents()
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]*indexedProduct, len(products))
+	for _, p := range products {
+		index[p.Id] = p
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.products = index
+	i.categories = categories
+	return nil
+}
+
+func (i *MemorySearchIndex) IndexProduct(id string) error {
+	products, err := loadIndexedProducts([]string{id})
+	if err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return i.DeleteProduct(id)
+	}
+
+	categories, err := loadCategoryParents()
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.products[id] = products[0]
+	i.categories = categories
+	return nil
+}
+
+func (i *MemorySearchIndex) DeleteProduct(id string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.products, id)
+	return nil
+}
+
+// inCategory reports whether the product belongs to the category or, with
+// withSubcategories, to one of its descendants.
+func (i *MemorySearchIndex) inCategory(p *indexedProduct, categoryId string, withSubcategories bool) bool {
+	if !withSubcategories {
+		return p.CategoryId == categoryId
+	}
+
+	current := &p.CategoryId
+	for depth := 0; current != nil && depth < len(i.categories)+1; depth++ {
+		if *current == categoryId {
+			return true
+		}
+		current = i.categories[*current]
+	}
+	return false
+}
+
+func hasTermWithPrefix(terms []string, prefix string) bool {
+	for _, t := range terms {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// relevance returns how well the product matches every word of the query, with
+// title matches weighted above description ones, or 0 if some word is missing.
+func (p *indexedProduct) relevance(words []string) float64 {
+	var result float64
+	for _, w := range words {
+		switch {
+		case hasTermWithPrefix(p.titleTerms, w):
+			result += 2
+		case hasTermWithPrefix(p.descriptionTerms, w):
+			result += 1
+		default:
+			return 0
+		}
+	}
+	return result
+}
+
+// matchesFilters reports whether the product has one of the selected variants
+// of every selected filter except the skipped one.
+func (p *indexedProduct) matchesFilters(filters map[string][]string, skip string) bool {
+	for filter, variants := range filters {
+		if filter == skip {
+			continue
+		}
+
+		found := false
+		for _, v := range p.Variants {
+			if v.Filter == filter && SliceContains(variants, v.Slug) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type scoredProduct struct {
+	*indexedProduct
+	score float64
+}
+
+// match returns the products matching everything in the request but the
+// selected filters, which are left to the callers.
+func (i *MemorySearchIndex) match(request *ProductsRequest) []scoredProduct {
+	words := tokenize(request.Search)
+	statuses := request.statuses()
+
+	result := make([]scoredProduct, 0)
+	for _, p := range i.products {
+		if !SliceContains(statuses, p.Status) {
+			continue
+		}
+		if request.CategoryId != "" && !i.inCategory(p, request.CategoryId, request.WithSubcategories) {
+			continue
+		}
+		if len(request.Ids) > 0 && !SliceContains(request.Ids, p.Id) {
+			continue
+		}
+		if request.MinPrice > 0 && p.Price < request.MinPrice {
+			continue
+		}
+		if request.MaxPrice > 0 && p.Price > request.MaxPrice {
+			continue
+		}
+		if request.MinRating > 0 && p.Rating < request.MinRating {
+			continue
+		}
+
+		var score float64
+		if len(words) > 0 {
+			if score = p.relevance(words); score == 0 {
+				continue
+			}
+		}
+		result = append(result, scoredProduct{p, score})
+	}
+	return result
+}
+
+// lessScoredProducts orders products like GetProducts does, ties broken by id.
+func lessScoredProducts(x, y *scoredProduct, orderBy string) bool {
+	switch orderBy {
+	case "rating":
+		if x.Rating != y.Rating {
+			return x.Rating > y.Rating
+		}
+		if x.Reviews != y.Reviews {
+			return x.Reviews > y.Reviews
+		}
+		return x.Id > y.Id
+	case "cheap":
+		if x.Price != y.Price {
+			return x.Price < y.Price
+		}
+		return x.Id < y.Id
+	case "expensive":
+		if x.Price != y.Price {
+			return x.Price > y.Price
+		}
+		return x.Id > y.Id
+	case "relevance":
+		if x.score != y.score {
+			return x.score > y.score
+		}
+		if x.Rating != y.Rating {
+			return x.Rating > y.Rating
+		}
+		return x.Id > y.Id
+	default:
+		if !x.CreatedAt.Equal(y.CreatedAt) {
+			return x.CreatedAt.After(y.CreatedAt)
+		}
+		return x.Id > y.Id
+	}
+}
+
+func sortScoredProducts(products []scoredProduct, orderBy string) {
+	sort.Slice(products, func(a, b int) bool {
+		return lessScoredProducts(&products[a], &products[b], orderBy)
+	})
+}
+
+func (p *scoredProduct) cursor(orderBy string) *Cursor {
+	cursor := &Cursor{OrderBy: orderBy, Id: p.Id}
+	switch orderBy {
+	case "rating":
+		cursor.Keys = []string{cursorNumber(p.Rating), cursorInteger(p.Reviews)}
+	case "cheap", "expensive":
+		cursor.Keys = []string{cursorInteger(p.Price)}
+	case "relevance":
+		cursor.Keys = []string{cursorNumber(p.score), cursorNumber(p.Rating)}
+	default:
+		cursor.Keys = []string{cursorTime(p.CreatedAt)}
+	}
+	return cursor
+}
+
+// cursorProduct restores the sort keys of the product a cursor points at,
+// which may be gone from the index by now. The keys were checked by
+// ParseCursor.
+func cursorProduct(c *Cursor) *scoredProduct {
+	p := &scoredProduct{indexedProduct: &indexedProduct{Id: c.Id}}
+	number := func(k int) float64 {
+		n, _ := strconv.ParseFloat(c.Keys[k], 64)
+		return n
+	}
+
+	switch c.OrderBy {
+	case "rating":
+		p.Rating, p.Reviews = number(0), uint64(number(1))
+	case "cheap", "expensive":
+		p.Price = uint64(number(0))
+	case "relevance":
+		p.score, p.Rating = number(0), number(1)
+	default:
+		p.CreatedAt, _ = time.Parse(time.RFC3339Nano, c.Keys[0])
+	}
+	return p
+}
+
+func (i *MemorySearchIndex) Query(request *ProductsRequest) ([]Product, *Cursor, *ProductsTotal, error) {
+	i.mu.RLock()
+	matched := i.match(request)
+	i.mu.RUnlock()
+
+	filtered := make([]scoredProduct, 0, len(matched))
+	for _, p := range matched {
+		if p.matchesFilters(request.Filters, "") {
+			filtered = append(filtered, p)
+		}
+	}
+	sortScoredProducts(filtered, request.OrderBy)
+
+	var total *ProductsTotal
+	if !request.IsKeyset() || !request.SkipTotal {
+		total = newProductsTotal(uint64(len(filtered)), &request.Pagination)
+	}
+
+	var next *Cursor
+	size := request.PageSize(PRODUCTS_PER_PAGE)
+	if request.IsKeyset() {
+		if request.Cursor != nil {
+			after := cursorProduct(request.Cursor)
+			from := sort.Search(len(filtered), func(k int) bool {
+				return lessScoredProducts(after, &filtered[k], request.OrderBy)
+			})
+			filtered = filtered[from:]
+		}
+		if len(filtered) > size {
+			filtered = filtered[:size]
+			next = filtered[size-1].cursor(request.OrderBy)
+		}
+	} else if request.Page > 0 {
+		from := (request.Page - 1) * size
+		if from > len(filtered) {
+			from = len(filtered)
+		}
+		to := from + size
+		if to > len(filtered) {
+			to = len(filtered)
+		}
+		filtered = filtered[from:to]
+	}
+	if len(filtered) == 0 {
+		return make([]Product, 0), nil, total, nil
+	}
+
+	ids := make([]string, len(filtered))
+	for k, p := range filtered {
+		ids[k] = p.Id
+	}
+
+	products, _, err := GetProducts(&ProductsRequest{
+		WithTranslations: request.WithTranslations,
+		Lang:             request.Lang,
+		Ids:              ids,
+		Statuses:         request.statuses(),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// keep the order of the index, the database returns the page unordered
+	sortProductsByIds(products, ids)
+
+	return products, next, total, nil
+}
+
+func (i *MemorySearchIndex) Facets(request *ProductsRequest) (map[string]uint64, error) {
+	i.mu.RLock()
+	matched := i.match(request)
+	i.mu.RUnlock()
+
+	result := make(map[string]uint64)
+	for _, p := range matched {
+		// a variant counts if the product matches the selection of every
+		// other filter, see GetFacetCounts
+		counted := make(map[string]bool)
+		for _, v := range p.Variants {
+			if counted[v.Id] || !p.matchesFilters(request.Filters, v.Filter) {
+				continue
+			}
+			counted[v.Id] = true
+			result[v.Id]++
+		}
+	}
+	return result, nil
+}
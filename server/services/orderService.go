@@ -8,12 +8,14 @@ import (
 	"time"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
-	"github.com/stripe/stripe-go/v76"
-	"github.com/stripe/stripe-go/v76/checkout/session"
+	"github.com/jackc/pgx/v5"
 	"github.com/yura4ka/vydelka/db"
+	"github.com/yura4ka/vydelka/payments"
 )
 
 var ErrCantCancel = errors.New("cannot cancel this order")
+var ErrOutOfStock = errors.New("not enough products in stock")
+var ErrUnknownProduct = errors.New("order contains unknown products")
 
 const ORDERS_PER_PAGE = 30
 
@@ -42,8 +44,31 @@ const (
 )
 
 type OrderProduct struct {
-	Id    string `json:"id" validate:"required"`
-	Count int    `json:"count" validate:"required,min=1"`
+	Id    string  `json:"id" validate:"required,uuid"`
+	SkuId *string `json:"skuId,omitempty" validate:"omitempty,uuid"`
+	Count int     `json:"count" validate:"required,min=1"`
+}
+
+func (p *OrderProduct) key() string {
+	if p.SkuId == nil {
+		return p.Id
+	}
+	return p.Id + "/" + *p.SkuId
+}
+
+// mergeOrderProducts sums the counts of repeated product and variant pairs.
+func mergeOrderProducts(products []OrderProduct) []OrderProduct {
+	result := make([]OrderProduct, 0, len(products))
+	indexes := make(map[string]int)
+	for _, p := range products {
+		if i, ok := indexes[p.key()]; ok {
+			result[i].Count += p.Count
+			continue
+		}
+		indexes[p.key()] = len(result)
+		result = append(result, p)
+	}
+	return result
 }
 
 type NewOrder struct {
@@ -51,6 +76,8 @@ type NewOrder struct {
 	DeliveryType DeliveryType   `json:"deliveryType" validate:"required" mod:"trim"`
 	Address      *string        `json:"address" validate:"required_if=DeliveryType delivery" mod:"trim"`
 	PaymentType  PayType        `json:"paymentType" validate:"required" mod:"trim"`
+	Provider     string         `json:"paymentProvider" mod:"trim"`
+	PromoCode    string         `json:"promoCode" validate:"max=64" mod:"trim,ucase"`
 	Products     []OrderProduct `json:"products" validate:"required,min=1,max=100"`
 }
 
@@ -61,8 +88,19 @@ func CreateOrder(order *NewOrder, userId, location string, lang Language) (strin
 	}
 	defer tx.Rollback(db.Ctx)
 
+	url, err := createOrder(&tx, order, userId, location, lang)
+	if err != nil {
+		return "", err
+	}
+
+	return url, tx.Commit(db.Ctx)
+}
+
+func createOrder(tx *pgx.Tx, order *NewOrder, userId, location string, lang Language) (string, error) {
+	order.Products = mergeOrderProducts(order.Products)
+
 	var id string
-	err = pgxscan.Get(db.Ctx, tx, &id, `
+	err := pgxscan.Get(db.Ctx, *tx, &id, `
 		INSERT INTO orders (delivery, delivery_address, pay, user_id, region)
 		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id;
@@ -71,184 +109,312 @@ func CreateOrder(order *NewOrder, userId, location string, lang Language) (strin
 		return "", err
 	}
 
-	values := make([]string, len(order.Products))
-	args := make([]any, 0)
-	args = append(args, id)
-	argsCnt := 1
+	lines, err := addOrderContent(tx, id, order.Products, lang)
+	if err != nil {
+		return "", err
+	}
 
-	for i, p := range order.Products {
-		values[i] = fmt.Sprintf("($1, $%v, $%v)", argsCnt+1, argsCnt+2)
-		args = append(args, p.Id, p.Count)
-		argsCnt += 2
+	var discount uint64
+	if order.PromoCode != "" {
+		discount, err = applyPromoCode(tx, order.PromoCode, userId, id, lines)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	_, err = tx.Exec(db.Ctx, `
-		INSERT INTO order_content (order_id, product_id, quantity)
-		VALUES
-	`+strings.Join(values, ", "), args...)
-	if err != nil {
+	if err := reserveStock(tx, order.Products); err != nil {
+		return "", err
+	}
+
+	if err := addStatusHistory(tx, id, ORDER_PROCESSING, &userId); err != nil {
 		return "", err
 	}
 
 	order.Id = id
 	var url string
 	if order.PaymentType == PAY_NOW {
-		s, err := createStripeSession(order, userId, lang)
+		provider, err := payments.Get(order.Provider)
 		if err != nil {
 			return "", err
 		}
-		url = s.URL
 
-		expires := time.Unix(s.ExpiresAt, 0)
-		_, err = tx.Exec(db.Ctx, `
+		s, err := createPaymentSession(provider, order, lines, discount, userId)
+		if err != nil {
+			return "", err
+		}
+		url = s.Url
+
+		_, err = (*tx).Exec(db.Ctx, `
 			UPDATE orders 
-			SET payment_expiration_time = $1, stripe_session_id = $2, stripe_url = $3
-			WHERE id = $4;
-		`, expires, s.ID, url, id)
+			SET payment_expiration_time = $1, payment_provider = $2, payment_reference = $3, payment_url = $4
+			WHERE id = $5;
+		`, s.ExpiresAt, provider.Name(), s.Reference, url, id)
 		if err != nil {
 			return "", err
 		}
 	}
 
-	return url, tx.Commit(db.Ctx)
+	return url, nil
 }
 
-func createStripeSession(order *NewOrder, userId string, lang Language) (*stripe.CheckoutSession, error) {
-	ids := make([]string, len(order.Products))
-	productCost := make(map[string]int)
-	for i, v := range order.Products {
-		ids[i] = v.Id
-		productCost[v.Id] = v.Count
+func addOrderContent(tx *pgx.Tx, orderId string, products []OrderProduct, lang Language) ([]OrderLine, error) {
+	values := make([]string, len(products))
+	args := make([]any, 0)
+	args = append(args, orderId, lang)
+	argsCnt := 2
+
+	for i, p := range products {
+		values[i] = fmt.Sprintf("($%v::uuid, $%v::uuid, $%v::int)", argsCnt+1, argsCnt+2, argsCnt+3)
+		args = append(args, p.Id, p.SkuId, p.Count)
+		argsCnt += 3
 	}
 
-	products, err := GetProducts(&ProductsRequest{
-		Lang: lang,
-		Ids:  ids,
-	})
+	lines := make([]OrderLine, 0, len(products))
+	err := pgxscan.Select(db.Ctx, *tx, &lines, `
+		INSERT INTO order_content (order_id, product_id, sku_id, sku_code, quantity, price, title, image_url)
+		SELECT $1, p.id, ps.id, ps.code, r.quantity, COALESCE(ps.price, s.price, p.price),
+			COALESCE((
+				SELECT jsonb_object_agg(pt.lang, pt.title)
+				FROM product_translations AS pt
+				WHERE pt.product_id = p.id
+			), '{}'),
+			COALESCE((
+				SELECT si.image_url
+				FROM sku_images AS si
+				WHERE si.sku_id = ps.id
+				LIMIT 1
+			), (
+				SELECT pi.image_url
+				FROM product_images AS pi
+				WHERE pi.product_id = p.id
+				LIMIT 1
+			))
+		FROM (VALUES `+strings.Join(values, ", ")+`) AS r (id, sku_id, quantity)
+		INNER JOIN products AS p ON r.id = p.id
+		LEFT JOIN product_skus AS ps ON r.sku_id = ps.id AND ps.product_id = p.id
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
+		WHERE p.status = 'published' AND CASE WHEN r.sku_id IS NULL
+			THEN NOT EXISTS(SELECT 1 FROM product_skus AS x WHERE x.product_id = p.id)
+			ELSE ps.id IS NOT NULL
+		END
+		RETURNING id, product_id, sku_id, sku_code, quantity, price, title ->> $2::TEXT AS title, image_url;
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := GetUserById(userId)
-	if err != nil {
-		return nil, err
+	if len(lines) != len(products) {
+		return nil, ErrUnknownProduct
 	}
+	return lines, nil
+}
 
-	lineItems := make([]*stripe.CheckoutSessionLineItemParams, len(products))
-	for i, p := range products {
-		lineItems[i] = &stripe.CheckoutSessionLineItemParams{
-			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-					Name:   p.Title,
-					Images: stripe.StringSlice([]string{p.Images[0].ImageUrl}),
-				},
-				UnitAmount:  stripe.Int64(int64(p.Price)),
-				Currency:    stripe.String(string(stripe.CurrencyUAH)),
-				TaxBehavior: stripe.String(string(stripe.TaxCalculationLineItemTaxBehaviorExclusive)),
-			},
-			Quantity: stripe.Int64(int64(productCost[p.Id])),
+// reserveStock takes the ordered quantities from the variants stock, or from
+// the product stock for products without variants.
+func reserveStock(tx *pgx.Tx, products []OrderProduct) error {
+	ids := make([]string, 0)
+	counts := make([]int, 0)
+	skuIds := make([]string, 0)
+	skuCounts := make([]int, 0)
+	for _, p := range products {
+		if p.SkuId != nil {
+			skuIds = append(skuIds, *p.SkuId)
+			skuCounts = append(skuCounts, p.Count)
+		} else {
+			ids = append(ids, p.Id)
+			counts = append(counts, p.Count)
 		}
 	}
 
-	params := &stripe.CheckoutSessionParams{
-		CustomerEmail: &user.Email,
-		LineItems:     lineItems,
-		Mode:          stripe.String(string(stripe.CheckoutSessionModePayment)),
-		Metadata: map[string]string{
-			"orderId": order.Id,
-		},
-		SuccessURL: stripe.String(os.Getenv("CLIENT_ADDR") + "/orders?success"),
-		CancelURL:  stripe.String(os.Getenv("CLIENT_ADDR") + "/orders?canceled"),
+	reserved, err := decreaseStock(tx, "products", ids, counts)
+	if err != nil {
+		return err
 	}
 
-	s, err := session.New(params)
-	return s, err
+	reservedSkus, err := decreaseStock(tx, "product_skus", skuIds, skuCounts)
+	if err != nil {
+		return err
+	}
+
+	if reserved+reservedSkus != len(products) {
+		return ErrOutOfStock
+	}
+	return nil
 }
 
-func ConfirmOrder(id string) error {
-	_, err := db.Client.Exec(db.Ctx, `
-		UPDATE orders SET payment_time = $1
-		WHERE id = $2;
-	`, time.Now(), id)
-	return err
+func decreaseStock(tx *pgx.Tx, table string, ids []string, counts []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var updated []string
+	err := pgxscan.Select(db.Ctx, *tx, &updated, `
+		UPDATE `+table+` AS p SET stock = p.stock - r.quantity
+		FROM (
+			SELECT UNNEST($1::uuid[]) AS id, UNNEST($2::int[]) AS quantity
+		) AS r
+		WHERE p.id = r.id AND p.stock >= r.quantity
+		RETURNING p.id;
+	`, ids, counts)
+	return len(updated), err
 }
 
-func ExpirePayment(id string) error {
-	_, err := db.Client.Exec(db.Ctx, `
-		UPDATE orders SET status = $1
-		WHERE id = $2;
-	`, ORDER_EXPIRED, id)
+func releaseStock(tx *pgx.Tx, orderId string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		UPDATE products AS p SET stock = p.stock + c.quantity - c.refunded_quantity
+		FROM order_content AS c
+		WHERE c.order_id = $1 AND c.product_id = p.id AND c.sku_id IS NULL;
+	`, orderId)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE product_skus AS s SET stock = s.stock + c.quantity - c.refunded_quantity
+		FROM order_content AS c
+		WHERE c.order_id = $1 AND c.sku_id = s.id;
+	`, orderId)
 	return err
 }
 
-func CancelOrder(id, userId string) error {
-	var order struct {
-		Status      OrderStatus
-		PaymentTime *time.Time
+func createPaymentSession(provider payments.Provider, order *NewOrder, lines []OrderLine, discount uint64, userId string) (*payments.Session, error) {
+	user, err := GetUserById(userId)
+	if err != nil {
+		return nil, err
 	}
 
-	err := pgxscan.Get(db.Ctx, db.Client, &order, `
-		SELECT status, payment_time
-		FROM orders
-		WHERE id = $1 AND user_id = $2
-	`, id, userId)
-
-	if pgxscan.NotFound(err) {
-		return ErrCantCancel
+	items := make([]payments.LineItem, len(lines))
+	for i, l := range lines {
+		title := l.Title
+		if l.SkuCode != nil {
+			title += " (" + *l.SkuCode + ")"
+		}
+		items[i] = payments.LineItem{
+			Title:    title,
+			ImageUrl: l.ImageUrl,
+			Price:    l.Price,
+			Quantity: l.Quantity,
+		}
 	}
 
+	return provider.CreateSession(&payments.SessionRequest{
+		OrderId:       order.Id,
+		CustomerEmail: user.Email,
+		Lines:         items,
+		Discount:      discount,
+		PromoCode:     order.PromoCode,
+		SuccessUrl:    os.Getenv("CLIENT_ADDR") + "/orders?success",
+		CancelUrl:     os.Getenv("CLIENT_ADDR") + "/orders?canceled",
+	})
+}
+
+func ConfirmOrder(id, transaction string) error {
+	tx, err := db.Client.Begin(db.Ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(db.Ctx)
 
-	if order.PaymentTime != nil || !(order.Status == ORDER_PROCESSING || order.Status == ORDER_CONFIRMED) {
-		return ErrCantCancel
+	if err := confirmOrder(&tx, id, transaction); err != nil {
+		return err
 	}
 
-	_, err = db.Client.Exec(db.Ctx, `
-		UPDATE orders SET status = $1
-		WHERE id = $2 AND user_id = $3;
-	`, ORDER_CANCELED, id, userId)
+	return tx.Commit(db.Ctx)
+}
+
+func confirmOrder(tx *pgx.Tx, id, transaction string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		UPDATE orders
+		SET payment_time = $1, payment_transaction = $2, payment_error = NULL
+		WHERE id = $3 AND payment_time IS NULL AND status = ANY($4::order_status[]);
+	`, time.Now(), transaction, id, []OrderStatus{ORDER_PROCESSING, ORDER_CONFIRMED})
+	return err
+}
+
+func getOrderTotal(tx *pgx.Tx, id string) (uint64, error) {
+	var total uint64
+	err := pgxscan.Get(db.Ctx, *tx, &total, `
+		SELECT COALESCE(SUM(price * quantity - discount), 0)
+		FROM order_content
+		WHERE order_id = $1;
+	`, id)
+	return total, err
+}
+
+func expirePayment(tx *pgx.Tx, id string) error {
+	err := setOrderStatus(tx, id, ORDER_EXPIRED, nil, nil)
+	if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrOrderNotFound) {
+		return nil
+	}
+	return err
+}
 
+func CancelOrder(id, userId string) error {
+	err := changeOrderStatus(id, ORDER_CANCELED, &userId, &userId)
+	if errors.Is(err, ErrIllegalTransition) {
+		err = RefundOrder(id, nil, userId, &userId)
+	}
+	if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrCantRefund) {
+		return ErrCantCancel
+	}
 	return err
 }
 
 type Order struct {
-	Id                    string       `json:"id"`
-	CreatedAt             time.Time    `json:"createdAt"`
-	Delivery              DeliveryType `json:"deliveryType"`
-	DeliveryAddress       *string      `json:"deliveryAddress,omitempty"`
-	Pay                   PayType      `json:"payType"`
-	PaymentTime           *time.Time   `json:"paymentTime,omitempty"`
-	StripeUrl             *string      `json:"stripeUrl,omitempty"`
-	Status                OrderStatus  `json:"status"`
-	TakeoutExpirationTime *time.Time   `json:"takeoutExpirationTime,omitempty"`
-	Total                 uint64       `json:"total"`
-	ItemsCount            int          `json:"itemsCount"`
+	Id                    string        `json:"id"`
+	CreatedAt             time.Time     `json:"createdAt"`
+	Delivery              DeliveryType  `json:"deliveryType"`
+	DeliveryAddress       *string       `json:"deliveryAddress,omitempty"`
+	Pay                   PayType       `json:"payType"`
+	PaymentTime           *time.Time    `json:"paymentTime,omitempty"`
+	PaymentProvider       *string       `json:"paymentProvider,omitempty"`
+	PaymentUrl            *string       `json:"paymentUrl,omitempty"`
+	Status                OrderStatus   `json:"status"`
+	TakeoutExpirationTime *time.Time    `json:"takeoutExpirationTime,omitempty"`
+	PaymentError          *string       `json:"paymentError,omitempty"`
+	RefundedAmount        uint64        `json:"refundedAmount"`
+	RefundStatus          *RefundStatus `json:"refundStatus,omitempty"`
+	RefundedAt            *time.Time    `json:"refundedAt,omitempty"`
+	PromoCode             *string       `json:"promoCode,omitempty"`
+	Discount              uint64        `json:"discount"`
+	Total                 uint64        `json:"total"`
+	ItemsCount            int           `json:"itemsCount"`
 }
 
-func GetOrders(userId string, page int) ([]Order, error) {
+func GetOrders(userId string, p *Pagination) ([]Order, *Cursor, error) {
+	createdAt, id := newestCursorArgs(p.Cursor)
+	limit, offset := p.limitOffset(ORDERS_PER_PAGE)
+
 	orders := make([]Order, 0)
-	limit := ORDERS_PER_PAGE
-	offset := (page - 1) * ORDERS_PER_PAGE
 	err := pgxscan.Select(db.Ctx, db.Client, &orders, `
 		SELECT 
 			o.id, o.created_at, o.delivery, o.delivery_address, o.pay,
-			o.payment_time, o.stripe_url, o.status, o.takeout_expiration_time,
-			SUM(p.price * c.quantity) AS total,
+			o.payment_time, o.payment_provider, o.payment_url, o.status, o.takeout_expiration_time,
+			o.payment_error, o.refunded_amount, o.refund_status, o.refunded_at, o.promo_code, o.discount,
+			SUM(c.price * c.quantity) - o.discount AS total,
 			COUNT(c.*) AS items_count
 		FROM orders AS o
 		INNER JOIN order_content AS c ON o.id = c.order_id
-		LEFT JOIN products AS p ON c.product_id = p.id
 		WHERE o.user_id = $1
+			AND ($4::TIMESTAMPTZ IS NULL OR (o.created_at, o.id) < ($4::TIMESTAMPTZ, $5::UUID))
 		GROUP BY o.id
-		ORDER BY o.created_at DESC
+		ORDER BY o.created_at DESC, o.id DESC
 		LIMIT $2 OFFSET $3;
-	`, userId, limit, offset)
-	return orders, err
+	`, userId, limit, offset, createdAt, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	orders, hasMore := trimPage(orders, p, ORDERS_PER_PAGE)
+	if hasMore {
+		last := orders[len(orders)-1]
+		next = newestCursor(last.CreatedAt, last.Id)
+	}
+	return orders, next, nil
 }
 
-func HasMoreOrders(userId string, page int) (bool, int, error) {
+func HasMoreOrders(userId string, p *Pagination) (bool, int, error) {
 	var total int
 	err := pgxscan.Get(db.Ctx, db.Client, &total, `
 		SELECT COUNT(*) FROM orders
@@ -258,8 +424,207 @@ func HasMoreOrders(userId string, page int) (bool, int, error) {
 		return false, 0, err
 	}
 
-	hasMore := total > page*ORDERS_PER_PAGE
-	totalPages := (total + ORDERS_PER_PAGE - 1) / ORDERS_PER_PAGE
+	hasMore, totalPages := pageCounts(total, p, ORDERS_PER_PAGE)
 
 	return hasMore, totalPages, nil
 }
+
+type OrdersFilter struct {
+	Status []OrderStatus
+	From   *time.Time
+	To     *time.Time
+	UserId string
+	Region string
+}
+
+func createOrdersFilterQuery(filter *OrdersFilter) (string, []any) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+
+	if len(filter.Status) > 0 {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("o.status = ANY($%v::order_status[])", len(args)))
+	}
+	if filter.From != nil {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("o.created_at >= $%v", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("o.created_at < $%v", len(args)))
+	}
+	if filter.UserId != "" {
+		args = append(args, filter.UserId)
+		conditions = append(conditions, fmt.Sprintf("o.user_id = $%v", len(args)))
+	}
+	if filter.Region != "" {
+		args = append(args, filter.Region)
+		conditions = append(conditions, fmt.Sprintf("o.region = $%v", len(args)))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", args
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+type AdminOrder struct {
+	Order
+	UserId string  `json:"userId"`
+	Region *string `json:"region,omitempty"`
+}
+
+func GetAllOrders(filter *OrdersFilter, p *Pagination) ([]AdminOrder, *Cursor, error) {
+	where, args := createOrdersFilterQuery(filter)
+	if p.Cursor != nil {
+		args = append(args, cursorArgs(p.Cursor)...)
+		where += " AND " + keysetCondition(len(args)-1, true,
+			[2]string{"o.created_at", "TIMESTAMPTZ"}, [2]string{"o.id", "UUID"})
+	}
+	limit, offset := p.limitOffset(ORDERS_PER_PAGE)
+	args = append(args, limit, offset)
+
+	orders := make([]AdminOrder, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &orders, fmt.Sprintf(`
+		SELECT 
+			o.id, o.created_at, o.delivery, o.delivery_address, o.pay,
+			o.payment_time, o.payment_provider, o.payment_url, o.status, o.takeout_expiration_time,
+			o.payment_error, o.refunded_amount, o.refund_status, o.refunded_at, o.promo_code, o.discount,
+			o.user_id, o.region,
+			SUM(c.price * c.quantity) - o.discount AS total,
+			COUNT(c.*) AS items_count
+		FROM orders AS o
+		INNER JOIN order_content AS c ON o.id = c.order_id
+		WHERE %s
+		GROUP BY o.id
+		ORDER BY o.created_at DESC, o.id DESC
+		LIMIT $%v OFFSET $%v;
+	`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	orders, hasMore := trimPage(orders, p, ORDERS_PER_PAGE)
+	if hasMore {
+		last := orders[len(orders)-1]
+		next = newestCursor(last.CreatedAt, last.Id)
+	}
+	return orders, next, nil
+}
+
+func HasMoreAllOrders(filter *OrdersFilter, p *Pagination) (bool, int, error) {
+	where, args := createOrdersFilterQuery(filter)
+
+	var total int
+	err := pgxscan.Get(db.Ctx, db.Client, &total, `
+		SELECT COUNT(*) FROM orders AS o
+		WHERE `+where+`;
+	`, args...)
+	if err != nil {
+		return false, 0, err
+	}
+
+	hasMore, totalPages := pageCounts(total, p, ORDERS_PER_PAGE)
+
+	return hasMore, totalPages, nil
+}
+
+type OrderLine struct {
+	Id               string   `json:"id"`
+	ProductId        *string  `json:"productId,omitempty"`
+	SkuId            *string  `json:"skuId,omitempty"`
+	SkuCode          *string  `json:"skuCode,omitempty"`
+	Slug             *string  `json:"slug,omitempty"`
+	Title            string   `json:"title"`
+	ImageUrl         *string  `json:"imageUrl,omitempty"`
+	Price            uint64   `json:"price"`
+	Quantity         int      `json:"quantity"`
+	RefundedQuantity int      `json:"refundedQuantity"`
+	Discount         uint64   `json:"discount"`
+	Product          *Product `json:"product,omitempty" db:"-"`
+}
+
+type OrderInfo struct {
+	AdminOrder
+	Lines   []OrderLine         `json:"lines"`
+	History []OrderStatusChange `json:"history"`
+}
+
+func GetOrderById(id string, ownerId *string, lang Language) (*OrderInfo, error) {
+	result := new(OrderInfo)
+	err := pgxscan.Get(db.Ctx, db.Client, &result.AdminOrder, `
+		SELECT 
+			o.id, o.created_at, o.delivery, o.delivery_address, o.pay,
+			o.payment_time, o.payment_provider, o.payment_url, o.status, o.takeout_expiration_time,
+			o.payment_error, o.refunded_amount, o.refund_status, o.refunded_at, o.promo_code, o.discount,
+			o.user_id, o.region,
+			SUM(c.price * c.quantity) - o.discount AS total,
+			COUNT(c.*) AS items_count
+		FROM orders AS o
+		INNER JOIN order_content AS c ON o.id = c.order_id
+		WHERE o.id = $1 AND ($2::uuid IS NULL OR o.user_id = $2)
+		GROUP BY o.id;
+	`, id, ownerId)
+	if pgxscan.NotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Lines = make([]OrderLine, 0)
+	err = pgxscan.Select(db.Ctx, db.Client, &result.Lines, `
+		SELECT c.id, c.product_id, c.sku_id, c.sku_code, p.slug, c.title ->> $2::TEXT AS title, c.image_url,
+			c.price, c.quantity, c.refunded_quantity, c.discount
+		FROM order_content AS c
+		LEFT JOIN products AS p ON c.product_id = p.id
+		WHERE c.order_id = $1;
+	`, id, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachLineProducts(result.Lines, lang); err != nil {
+		return nil, err
+	}
+
+	result.History, err = GetOrderHistory(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func attachLineProducts(lines []OrderLine, lang Language) error {
+	ids := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if l.ProductId != nil {
+			ids = append(ids, *l.ProductId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	products, _, err := GetProducts(&ProductsRequest{
+		Lang: lang,
+		Ids:  ids,
+	})
+	if err != nil {
+		return err
+	}
+
+	productMap := make(map[string]*Product)
+	for i := range products {
+		productMap[products[i].Id] = &products[i]
+	}
+
+	for i, l := range lines {
+		if l.ProductId != nil {
+			lines[i].Product = productMap[*l.ProductId]
+		}
+	}
+	return nil
+}
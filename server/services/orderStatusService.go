@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+	"github.com/yura4ka/vydelka/payments"
+)
+
+var ErrIllegalTransition = errors.New("illegal order status transition")
+var ErrOrderNotFound = errors.New("order not found")
+
+const TAKEOUT_PERIOD = time.Hour * 24 * 5
+
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	ORDER_PROCESSING: {ORDER_CONFIRMED, ORDER_CANCELED, ORDER_EXPIRED},
+	ORDER_CONFIRMED:  {ORDER_RECEIVED, ORDER_CANCELED, ORDER_EXPIRED},
+}
+
+type orderState struct {
+	Status      OrderStatus
+	Pay         PayType
+	PaymentTime *time.Time
+}
+
+func canChangeStatus(order *orderState, status OrderStatus) bool {
+	if !SliceContains(orderTransitions[order.Status], status) {
+		return false
+	}
+
+	switch status {
+	case ORDER_CONFIRMED:
+		return order.Pay == PAY_RECEIVE || order.PaymentTime != nil
+	case ORDER_CANCELED, ORDER_EXPIRED:
+		return order.PaymentTime == nil
+	}
+	return true
+}
+
+func addStatusHistory(tx *pgx.Tx, orderId string, status OrderStatus, changedBy *string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		INSERT INTO order_status_history (order_id, status, changed_by)
+		VALUES ($1, $2, $3);
+	`, orderId, status, changedBy)
+	return err
+}
+
+func changeOrderStatus(id string, status OrderStatus, changedBy, ownerId *string) error {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	if err := setOrderStatus(&tx, id, status, changedBy, ownerId); err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+func setOrderStatus(tx *pgx.Tx, id string, status OrderStatus, changedBy, ownerId *string) error {
+	var order orderState
+	err := pgxscan.Get(db.Ctx, *tx, &order, `
+		SELECT status, pay, payment_time
+		FROM orders
+		WHERE id = $1 AND ($2::uuid IS NULL OR user_id = $2)
+		FOR UPDATE;
+	`, id, ownerId)
+	if pgxscan.NotFound(err) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !canChangeStatus(&order, status) {
+		return ErrIllegalTransition
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE orders SET status = $1::order_status,
+			payment_time = CASE
+				WHEN $1::order_status = 'received' THEN COALESCE(payment_time, NOW())
+				ELSE payment_time
+			END,
+			takeout_expiration_time = CASE
+				WHEN $1::order_status = 'confirmed' AND delivery = 'self' THEN $3
+				ELSE takeout_expiration_time
+			END
+		WHERE id = $2;
+	`, status, id, time.Now().Add(TAKEOUT_PERIOD))
+	if err != nil {
+		return err
+	}
+
+	if status == ORDER_CANCELED || status == ORDER_EXPIRED {
+		if err := releaseStock(tx, id); err != nil {
+			return err
+		}
+	}
+
+	return addStatusHistory(tx, id, status, changedBy)
+}
+
+func ChangeOrderStatus(id string, status OrderStatus, adminId string) error {
+	return changeOrderStatus(id, status, &adminId, nil)
+}
+
+type OrderStatusChange struct {
+	CreatedAt time.Time   `json:"createdAt"`
+	Status    OrderStatus `json:"status"`
+	ChangedBy *string     `json:"changedBy,omitempty"`
+}
+
+func GetOrderHistory(orderId string) ([]OrderStatusChange, error) {
+	history := make([]OrderStatusChange, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &history, `
+		SELECT created_at, status, changed_by
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at;
+	`, orderId)
+	return history, err
+}
+
+func expireOrders(query string) error {
+	var ids []string
+	err := pgxscan.Select(db.Ctx, db.Client, &ids, query)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := changeOrderStatus(id, ORDER_EXPIRED, nil, nil)
+		if err != nil && !errors.Is(err, ErrIllegalTransition) && !errors.Is(err, ErrOrderNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func ExpireTakeoutOrders() error {
+	return expireOrders(`
+		SELECT id FROM orders
+		WHERE delivery = 'self' AND status = 'confirmed' AND payment_time IS NULL
+			AND takeout_expiration_time < NOW();
+	`)
+}
+
+type unpaidOrder struct {
+	Id               string
+	PaymentProvider  *string
+	PaymentReference *string
+}
+
+// checkPayment asks the provider about the payment of an order whose webhook
+// may have been lost or delayed. A session that is still open is expired
+// first, so it can't be paid after the order expires.
+func checkPayment(order *unpaidOrder) (*payments.PaymentStatus, error) {
+	if order.PaymentProvider == nil || order.PaymentReference == nil {
+		return &payments.PaymentStatus{Status: payments.STATUS_EXPIRED}, nil
+	}
+
+	provider, err := payments.Get(*order.PaymentProvider)
+	if err != nil {
+		return nil, err
+	}
+	status, err := provider.GetStatus(*order.PaymentReference)
+	if err != nil || status.Status != payments.STATUS_PENDING {
+		return status, err
+	}
+
+	if err := provider.ExpireSession(*order.PaymentReference); err != nil {
+		// it may have been paid meanwhile, the status tells
+		log.Printf("failed to expire payment session of order %s: %v", order.Id, err)
+	}
+	return provider.GetStatus(*order.PaymentReference)
+}
+
+func ExpireUnpaidOrders() error {
+	orders := make([]unpaidOrder, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &orders, `
+		SELECT id, payment_provider, payment_reference FROM orders
+		WHERE pay = 'pay_now' AND status = 'processing' AND payment_time IS NULL
+			AND payment_transaction IS NULL AND payment_expiration_time < NOW();
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		status, err := checkPayment(&order)
+		if err != nil {
+			// better to keep the order until the next run than to release the stock of a paid one
+			log.Printf("failed to check payment of order %s: %v", order.Id, err)
+			continue
+		}
+
+		switch status.Status {
+		case payments.STATUS_PAID:
+			err = ConfirmOrder(order.Id, status.Transaction)
+		case payments.STATUS_EXPIRED:
+			err = changeOrderStatus(order.Id, ORDER_EXPIRED, nil, nil)
+		default:
+			// the session is still open, it's checked again on the next run
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrIllegalTransition) && !errors.Is(err, ErrOrderNotFound) {
+			return err
+		}
+	}
+	return nil
+}
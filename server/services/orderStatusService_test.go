@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanChangeStatus(t *testing.T) {
+	paid := time.Now()
+
+	tests := []struct {
+		name   string
+		order  orderState
+		status OrderStatus
+		want   bool
+	}{
+		{"confirm cash on delivery", orderState{ORDER_PROCESSING, PAY_RECEIVE, nil}, ORDER_CONFIRMED, true},
+		{"confirm paid", orderState{ORDER_PROCESSING, PAY_NOW, &paid}, ORDER_CONFIRMED, true},
+		{"confirm unpaid", orderState{ORDER_PROCESSING, PAY_NOW, nil}, ORDER_CONFIRMED, false},
+		{"cancel unpaid", orderState{ORDER_PROCESSING, PAY_NOW, nil}, ORDER_CANCELED, true},
+		{"cancel paid", orderState{ORDER_PROCESSING, PAY_NOW, &paid}, ORDER_CANCELED, false},
+		{"expire unpaid", orderState{ORDER_PROCESSING, PAY_NOW, nil}, ORDER_EXPIRED, true},
+		{"expire paid", orderState{ORDER_CONFIRMED, PAY_NOW, &paid}, ORDER_EXPIRED, false},
+		{"receive confirmed", orderState{ORDER_CONFIRMED, PAY_RECEIVE, nil}, ORDER_RECEIVED, true},
+		{"receive processing", orderState{ORDER_PROCESSING, PAY_RECEIVE, nil}, ORDER_RECEIVED, false},
+		{"confirm twice", orderState{ORDER_CONFIRMED, PAY_RECEIVE, nil}, ORDER_CONFIRMED, false},
+		{"reopen received", orderState{ORDER_RECEIVED, PAY_RECEIVE, nil}, ORDER_PROCESSING, false},
+		{"cancel received", orderState{ORDER_RECEIVED, PAY_RECEIVE, nil}, ORDER_CANCELED, false},
+		{"confirm canceled", orderState{ORDER_CANCELED, PAY_RECEIVE, nil}, ORDER_CONFIRMED, false},
+		{"confirm expired", orderState{ORDER_EXPIRED, PAY_NOW, nil}, ORDER_CONFIRMED, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canChangeStatus(&tt.order, tt.status); got != tt.want {
+				t.Errorf("canChangeStatus(%v -> %v) = %v, want %v", tt.order.Status, tt.status, got, tt.want)
+			}
+		})
+	}
+}
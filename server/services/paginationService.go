@@ -0,0 +1,182 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const MAX_PAGE_SIZE = 100
+
+// NEWEST_FIRST is the ordering of lists without a choice of one, like orders
+// and reviews. Their cursors have the creation time as the only key.
+const NEWEST_FIRST = "new"
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type CursorKey int
+
+const (
+	CURSOR_TIME CursorKey = iota
+	CURSOR_NUMBER
+	CURSOR_INTEGER
+)
+
+// Cursor points right after the last row of a page in a keyset paginated
+// list. Keys are the values of the sort columns of that row, Id breaks ties.
+// Clients get it as an opaque string and send it back unchanged.
+type Cursor struct {
+	OrderBy string   `json:"o"`
+	Fuzzy   bool     `json:"f,omitempty"`
+	Keys    []string `json:"k"`
+	Id      string   `json:"i"`
+}
+
+func (c *Cursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor and checks that it was issued for the same
+// ordering and has keys of the expected kinds.
+func ParseCursor(value, orderBy string, keys ...CursorKey) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := new(Cursor)
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.OrderBy != orderBy || len(cursor.Keys) != len(keys) || ValidateVar(cursor.Id, "uuid") != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	for i, k := range keys {
+		switch k {
+		case CURSOR_TIME:
+			_, err = time.Parse(time.RFC3339Nano, cursor.Keys[i])
+		case CURSOR_NUMBER:
+			_, err = strconv.ParseFloat(cursor.Keys[i], 64)
+		case CURSOR_INTEGER:
+			_, err = strconv.ParseInt(cursor.Keys[i], 10, 64)
+		}
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return cursor, nil
+}
+
+func cursorTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func cursorNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// cursorInteger formats keys compared as BIGINT, which can't be cast from the
+// exponent notation cursorNumber uses for large values.
+func cursorInteger(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+// Pagination selects either a numbered page or, with a cursor or an explicit
+// limit and no page, the rows after a cursor. Page 0 without either means no
+// pagination at all.
+type Pagination struct {
+	Page      int
+	Limit     int
+	Cursor    *Cursor
+	SkipTotal bool
+}
+
+func (p *Pagination) IsKeyset() bool {
+	return p.Page == 0 && (p.Cursor != nil || p.Limit > 0)
+}
+
+func (p *Pagination) PageSize(defaultSize int) int {
+	if p.Limit > 0 {
+		return p.Limit
+	}
+	return defaultSize
+}
+
+// limitOffset returns the LIMIT and OFFSET arguments. In keyset mode one
+// extra row is requested to find out if there is a next page.
+func (p *Pagination) limitOffset(defaultSize int) (pgtype.Int4, int) {
+	size := p.PageSize(defaultSize)
+	switch {
+	case p.IsKeyset():
+		return pgtype.Int4{Int32: int32(size + 1), Valid: true}, 0
+	case p.Page > 0:
+		return pgtype.Int4{Int32: int32(size), Valid: true}, (p.Page - 1) * size
+	default:
+		return pgtype.Int4{}, 0
+	}
+}
+
+// trimPage drops the extra row requested by limitOffset and reports whether
+// it was there.
+func trimPage[T any](rows []T, p *Pagination, defaultSize int) ([]T, bool) {
+	size := p.PageSize(defaultSize)
+	if !p.IsKeyset() || len(rows) <= size {
+		return rows, false
+	}
+	return rows[:size], true
+}
+
+// keysetCondition compares the row of columns, given as expression and SQL
+// type pairs and followed by the row id, against the cursor keys passed as
+// arguments from the n-th.
+func keysetCondition(n int, desc bool, columns ...[2]string) string {
+	left := make([]string, len(columns))
+	right := make([]string, len(columns))
+	for i, c := range columns {
+		left[i] = c[0]
+		right[i] = fmt.Sprintf("$%d::%s", n+i, c[1])
+	}
+
+	operator := ">"
+	if desc {
+		operator = "<"
+	}
+	return fmt.Sprintf("(%s) %s (%s)", strings.Join(left, ", "), operator, strings.Join(right, ", "))
+}
+
+func cursorArgs(c *Cursor) []any {
+	args := make([]any, 0, len(c.Keys)+1)
+	for _, k := range c.Keys {
+		args = append(args, k)
+	}
+	return append(args, c.Id)
+}
+
+func newestCursor(createdAt time.Time, id string) *Cursor {
+	return &Cursor{OrderBy: NEWEST_FIRST, Keys: []string{cursorTime(createdAt)}, Id: id}
+}
+
+// newestCursorArgs returns the creation time and id of a NEWEST_FIRST cursor,
+// or NULLs without one.
+func newestCursorArgs(c *Cursor) (*string, *string) {
+	if c == nil {
+		return nil, nil
+	}
+	return &c.Keys[0], &c.Id
+}
+
+// pageCounts returns whether there are rows after the current page and the
+// number of pages.
+func pageCounts(total int, p *Pagination, defaultSize int) (bool, int) {
+	size := p.PageSize(defaultSize)
+	return total > p.Page*size, (total + size - 1) / size
+}
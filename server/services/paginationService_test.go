@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const cursorId = "5f0c6c84-8a49-4a34-9a9d-2a0f0d7a7c11"
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		desc    bool
+		columns [][2]string
+		want    string
+	}{
+		{
+			name:    "ascending",
+			n:       3,
+			columns: [][2]string{{"p.price", "BIGINT"}, {"p.id", "UUID"}},
+			want:    "(p.price, p.id) > ($3::BIGINT, $4::UUID)",
+		},
+		{
+			name:    "descending",
+			n:       1,
+			desc:    true,
+			columns: [][2]string{{"r.rating", "FLOAT8"}, {"r.cnt", "BIGINT"}, {"p.id", "UUID"}},
+			want:    "(r.rating, r.cnt, p.id) < ($1::FLOAT8, $2::BIGINT, $3::UUID)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keysetCondition(tt.n, tt.desc, tt.columns...); got != tt.want {
+				t.Errorf("keysetCondition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorKeys(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{cursorInteger(0), "0"},
+		{cursorInteger(999999), "999999"},
+		{cursorInteger(1000000), "1000000"},
+		{cursorInteger(123456789012), "123456789012"},
+		{cursorNumber(4.5), "4.5"},
+		{cursorNumber(0), "0"},
+		{cursorTime(time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)), "2024-03-01T12:00:00.0000005Z"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("cursor key = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	valid := &Cursor{OrderBy: "rating", Keys: []string{"4.5", cursorInteger(2000000)}, Id: cursorId}
+
+	got, err := ParseCursor(valid.String(), "rating", CURSOR_NUMBER, CURSOR_INTEGER)
+	if err != nil {
+		t.Fatalf("ParseCursor() error = %v", err)
+	}
+	if got.OrderBy != valid.OrderBy || got.Id != valid.Id || len(got.Keys) != 2 ||
+		got.Keys[0] != valid.Keys[0] || got.Keys[1] != valid.Keys[1] {
+		t.Errorf("ParseCursor() = %+v, want %+v", got, valid)
+	}
+
+	rating := []CursorKey{CURSOR_NUMBER, CURSOR_INTEGER}
+	tests := []struct {
+		name    string
+		value   string
+		orderBy string
+		keys    []CursorKey
+	}{
+		{"not base64", "%%%", "rating", rating},
+		{"not json", "bm90IGpzb24", "rating", rating},
+		{"other ordering", (&Cursor{OrderBy: "cheap", Keys: valid.Keys, Id: cursorId}).String(), "rating", rating},
+		{"missing key", (&Cursor{OrderBy: "rating", Keys: valid.Keys[:1], Id: cursorId}).String(), "rating", rating},
+		{"invalid id", (&Cursor{OrderBy: "rating", Keys: valid.Keys, Id: "1"}).String(), "rating", rating},
+		{"exponent integer", (&Cursor{OrderBy: "rating", Keys: []string{"4.5", "1e+06"}, Id: cursorId}).String(), "rating", rating},
+		{"not a number", (&Cursor{OrderBy: "rating", Keys: []string{"high", "1"}, Id: cursorId}).String(), "rating", rating},
+		{"not a time", (&Cursor{OrderBy: NEWEST_FIRST, Keys: []string{"yesterday"}, Id: cursorId}).String(), NEWEST_FIRST, []CursorKey{CURSOR_TIME}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCursor(tt.value, tt.orderBy, tt.keys...); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("ParseCursor() error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+	"github.com/yura4ka/vydelka/payments"
+)
+
+var ErrMalformedEvent = payments.ErrMalformedEvent
+
+// HandlePaymentEvent applies a webhook event once. The event is recorded in
+// the same transaction as its effects, so a failure leaves it to be retried
+// and a retry after a commit is a no-op.
+func HandlePaymentEvent(provider string, event *payments.Event) error {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	tag, err := tx.Exec(db.Ctx, `
+		INSERT INTO payment_events (provider, id, type)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING;
+	`, provider, event.Id, event.Type)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if err := dispatchPaymentEvent(&tx, provider, event); err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+func dispatchPaymentEvent(tx *pgx.Tx, provider string, event *payments.Event) error {
+	switch event.Type {
+	case payments.EVENT_PAID:
+		if ValidateVar(event.OrderId, "uuid") != nil {
+			return ErrMalformedEvent
+		}
+		return confirmPayment(tx, event)
+	case payments.EVENT_EXPIRED:
+		if ValidateVar(event.OrderId, "uuid") != nil {
+			return ErrMalformedEvent
+		}
+		return expirePayment(tx, event.OrderId)
+	case payments.EVENT_PAYMENT_FAILED:
+		if ValidateVar(event.OrderId, "uuid") != nil {
+			return ErrMalformedEvent
+		}
+		return setPaymentError(tx, event.OrderId, event.Message)
+	case payments.EVENT_REFUNDED:
+		return applyRefund(tx, provider, event.Transaction, uint64(event.Amount), event.FullyRefunded)
+	case payments.EVENT_REFUND_FAILED:
+		if event.RefundId != "" && ValidateVar(event.RefundId, "uuid") != nil {
+			return ErrMalformedEvent
+		}
+		return failRefund(tx, provider, event.Transaction, event.RefundId)
+	}
+
+	return nil
+}
+
+// confirmPayment confirms the order if the paid amount matches its total.
+// Otherwise the payment is kept on the order with an error for an admin to
+// resolve, and the order is no longer expired automatically.
+func confirmPayment(tx *pgx.Tx, event *payments.Event) error {
+	total, err := getOrderTotal(tx, event.OrderId)
+	if err != nil {
+		return err
+	}
+
+	if event.Currency != payments.CURRENCY || event.Amount != int64(total) {
+		log.Printf("order %s: paid %v %s, expected %v", event.OrderId, event.Amount, event.Currency, total)
+		_, err := (*tx).Exec(db.Ctx, `
+			UPDATE orders SET payment_error = $1, payment_transaction = $2
+			WHERE id = $3 AND payment_time IS NULL;
+		`, fmt.Sprintf("Paid %v %s, expected %v %s", event.Amount, event.Currency, total, payments.CURRENCY),
+			event.Transaction, event.OrderId)
+		return err
+	}
+
+	return confirmOrder(tx, event.OrderId, event.Transaction)
+}
+
+func setPaymentError(tx *pgx.Tx, orderId, message string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		UPDATE orders SET payment_error = $1
+		WHERE id = $2 AND payment_time IS NULL;
+	`, message, orderId)
+	return err
+}
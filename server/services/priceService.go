@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+)
+
+var ErrPriceNotFound = errors.New("price entry not found")
+var ErrInvalidSalePeriod = errors.New("sale must end after it starts")
+
+type PriceEntry struct {
+	Id        string     `json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Price     uint64     `json:"price"`
+	IsSale    bool       `json:"isSale"`
+	StartsAt  time.Time  `json:"startsAt"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+	CreatedBy *string    `json:"createdBy,omitempty"`
+}
+
+// recordPriceChange adds a regular price entry to the history unless the
+// price is the same as the last recorded one.
+func recordPriceChange(tx *pgx.Tx, productId string, price uint64, changedBy string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		INSERT INTO product_prices (product_id, price, created_by)
+		SELECT $1, $2, $3
+		WHERE $2::DECIMAL IS DISTINCT FROM (
+			SELECT price FROM product_prices
+			WHERE product_id = $1 AND NOT is_sale
+			ORDER BY starts_at DESC
+			LIMIT 1
+		);
+	`, productId, price, changedBy)
+	return err
+}
+
+func GetPriceHistory(productId string) ([]PriceEntry, error) {
+	result := make([]PriceEntry, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT id, created_at, price, is_sale, starts_at, ends_at, created_by
+		FROM product_prices
+		WHERE product_id = $1
+		ORDER BY starts_at DESC, created_at DESC;
+	`, productId)
+	if err != nil || len(result) > 0 {
+		return result, err
+	}
+
+	var exists bool
+	err = pgxscan.Get(db.Ctx, db.Client, &exists, `
+		SELECT EXISTS(SELECT 1 FROM products WHERE id = $1);
+	`, productId)
+	if err == nil && !exists {
+		return nil, ErrProductNotFound
+	}
+	return result, err
+}
+
+type NewSale struct {
+	Price    uint64     `json:"price" validate:"required,min=1"`
+	StartsAt *time.Time `json:"startsAt"`
+	EndsAt   *time.Time `json:"endsAt"`
+}
+
+func CreateSale(productId string, sale *NewSale, adminId string) (string, error) {
+	startsAt := time.Now()
+	if sale.StartsAt != nil && sale.StartsAt.After(startsAt) {
+		startsAt = *sale.StartsAt
+	}
+	if sale.EndsAt != nil && !sale.EndsAt.After(startsAt) {
+		return "", ErrInvalidSalePeriod
+	}
+
+	var id string
+	err := pgxscan.Get(db.Ctx, db.Client, &id, `
+		INSERT INTO product_prices (product_id, price, is_sale, starts_at, ends_at, created_by)
+		SELECT id, $2, TRUE, $3, $4, $5 FROM products WHERE id = $1
+		RETURNING id;
+	`, productId, sale.Price, startsAt, sale.EndsAt, adminId)
+	if pgxscan.NotFound(err) {
+		return "", ErrProductNotFound
+	}
+	return id, err
+}
+
+// EndSale removes a sale that hasn't started yet and cuts a running one short,
+// so the history keeps every price customers have actually seen.
+func EndSale(productId, saleId string) error {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	tag, err := tx.Exec(db.Ctx, `
+		DELETE FROM product_prices
+		WHERE id = $1 AND product_id = $2 AND is_sale AND starts_at > NOW();
+	`, saleId, productId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		tag, err = tx.Exec(db.Ctx, `
+			UPDATE product_prices SET ends_at = NOW()
+			WHERE id = $1 AND product_id = $2 AND is_sale AND (ends_at IS NULL OR ends_at > NOW());
+		`, saleId, productId)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrPriceNotFound
+		}
+	}
+
+	return tx.Commit(db.Ctx)
+}
@@ -1,18 +1,30 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/yura4ka/vydelka/db"
 )
 
 const PRODUCTS_PER_PAGE = 36
 
+var ErrProductNotFound = errors.New("product not found")
+
+type ProductStatus string
+
+const (
+	PRODUCT_DRAFT     ProductStatus = "draft"
+	PRODUCT_PUBLISHED ProductStatus = "published"
+	PRODUCT_ARCHIVED  ProductStatus = "archived"
+)
+
 type ProductImage struct {
 	Id       string `json:"id" validate:"required"`
 	ImageUrl string `json:"imageUrl" validate:"url"`
@@ -23,6 +35,7 @@ type ProductImage struct {
 type baseProductMutation struct {
 	Slug        string         `json:"slug" validate:"required" mod:"trim"`
 	Price       uint64         `json:"price" validate:"required,min=0"`
+	Stock       *int           `json:"stock" validate:"omitempty,min=0"`
 	Title       Translations   `json:"title" validate:"required"`
 	Description Translations   `json:"description" validate:"required"`
 	Filters     []string       `json:"filters" validate:"required"`
@@ -31,10 +44,15 @@ type baseProductMutation struct {
 
 type NewProduct struct {
 	baseProductMutation
-	CategoryId string `json:"categoryId" validate:"required" mod:"trim"`
+	CategoryId string        `json:"categoryId" validate:"required" mod:"trim"`
+	Status     ProductStatus `json:"status" validate:"omitempty,oneof=draft published" mod:"trim"`
 }
 
 func addProductFilters(tx *pgx.Tx, id string, filters []string) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
 	query := "INSERT INTO product_filters (product_id, variant_id) VALUES\n"
 	args := make([]any, len(filters)+1)
 	args[0] = id
@@ -66,24 +84,49 @@ func addProductImages(tx *pgx.Tx, id string, images []ProductImage) error {
 	return err
 }
 
-func CreateProduct(p *NewProduct) (string, error) {
+func CreateProduct(p *NewProduct, adminId string) (string, error) {
 	tx, err := db.Client.Begin(db.Ctx)
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback(db.Ctx)
 
+	id, err := createProduct(&tx, p, adminId)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(db.Ctx); err != nil {
+		return "", err
+	}
+
+	reindexProduct(id)
+	return id, nil
+}
+
+func createProduct(tx *pgx.Tx, p *NewProduct, adminId string) (string, error) {
+	// the admin form and the import don't send a status, and what they create
+	// is meant to go on sale right away
+	status := p.Status
+	if status == "" {
+		status = PRODUCT_PUBLISHED
+	}
+
 	var id string
-	err = pgxscan.Get(db.Ctx, tx, &id, `
-		INSERT INTO products (slug, price, category_id)
-		VALUES ($1, $2, $3)
+	err := pgxscan.Get(db.Ctx, *tx, &id, `
+		INSERT INTO products (slug, price, stock, category_id, status)
+		VALUES ($1, $2, COALESCE($3, 0), $4, $5)
 		RETURNING id;
-	`, p.Slug, p.Price, p.CategoryId)
+	`, p.Slug, p.Price, p.Stock, p.CategoryId, status)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = tx.Exec(db.Ctx, `
+	if err := recordPriceChange(tx, id, p.Price, adminId); err != nil {
+		return "", err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
 		INSERT INTO product_translations (product_id, lang, title, description)
 		VALUES ($1, $2, $3, $4), ($1, $5, $6, $7);
 	`, id, Languages.En, p.Title.En, p.Description.En, Languages.Ua, p.Title.Ua, p.Description.Ua)
@@ -91,21 +134,24 @@ func CreateProduct(p *NewProduct) (string, error) {
 		return "", err
 	}
 
-	if err := addProductFilters(&tx, id, p.Filters); err != nil {
+	if err := addProductFilters(tx, id, p.Filters); err != nil {
 		return "", err
 	}
 
-	if err := addProductImages(&tx, id, p.Images); err != nil {
+	if err := addProductImages(tx, id, p.Images); err != nil {
 		return "", err
 	}
 
-	return id, tx.Commit(db.Ctx)
+	return id, nil
 }
 
 type Product struct {
 	Id                      string           `json:"id"`
 	Slug                    string           `json:"slug"`
 	Price                   uint64           `json:"price"`
+	OldPrice                *uint64          `json:"oldPrice,omitempty"`
+	Stock                   int              `json:"stock"`
+	Status                  ProductStatus    `json:"status,omitempty"`
 	Title                   *string          `json:"title,omitempty"`
 	Description             *string          `json:"description,omitempty"`
 	TitleTranslations       *Translations    `json:"titleTranslations,omitempty"`
@@ -115,6 +161,8 @@ type Product struct {
 	Rating                  float64          `json:"rating"`
 	Reviews                 uint64           `json:"reviews"`
 	Popularity              uint64           `json:"-"`
+	Skus                    []Sku            `json:"skus,omitempty" db:"-"`
+	Snippet                 *string          `json:"snippet,omitempty"`
 }
 
 type productTranslation struct {
@@ -133,18 +181,122 @@ type dbProduct struct {
 	Product
 	Filters      []ProductFilter `json:"filters"`
 	Translations map[Language]productTranslation
+	CreatedAt    time.Time
+	Rank         float64
 }
 
 type ProductsRequest struct {
-	CategoryId       string
-	WithTranslations bool
-	Lang             Language
-	Page             int
-	Filters          map[string][]string
-	OrderBy          string
-	Ids              []string
-	Cnt              int
-	Search           string
+	CategoryId        string
+	WithTranslations  bool
+	Lang              Language
+	Filters           map[string][]string
+	OrderBy           string
+	Ids               []string
+	Cnt               int
+	Search            string
+	Fuzzy             bool
+	Statuses          []ProductStatus
+	WithSubcategories bool
+	MinPrice          uint64
+	MaxPrice          uint64
+	MinRating         float64
+	Pagination
+}
+
+// statuses returns the product states the request may see, which is only
+// published products unless an admin asked for more.
+func (r *ProductsRequest) statuses() []ProductStatus {
+	if len(r.Statuses) == 0 {
+		return []ProductStatus{PRODUCT_PUBLISHED}
+	}
+	return r.Statuses
+}
+
+// boundsArgs returns the arguments of the price and rating conditions in the
+// order the product queries consume them.
+func (r *ProductsRequest) boundsArgs() []any {
+	args := make([]any, 0, 3)
+	if r.MinPrice > 0 {
+		args = append(args, r.MinPrice)
+	}
+	if r.MaxPrice > 0 {
+		args = append(args, r.MaxPrice)
+	}
+	if r.MinRating > 0 {
+		args = append(args, r.MinRating)
+	}
+	return args
+}
+
+// categoryCondition matches products of the category passed as the n-th
+// argument and, if withSubcategories is set, of all its descendants.
+func categoryCondition(n int, withSubcategories bool) string {
+	if !withSubcategories {
+		return fmt.Sprintf("p.category_id = $%d", n)
+	}
+
+	return fmt.Sprintf(`p.category_id IN (
+		WITH RECURSIVE CategoryHierarchy AS (
+			SELECT c.id
+			FROM categories AS c
+			WHERE c.id = $%d
+
+			UNION ALL
+
+			SELECT c.id
+			FROM categories AS c
+			JOIN CategoryHierarchy AS ch ON c.parent_id = ch.id
+		)
+		SELECT id FROM CategoryHierarchy
+	)`, n)
+}
+
+// productCursorColumns returns the columns products are sorted by for each
+// ordering, ending with the id, and whether the order is descending.
+func productCursorColumns(orderBy string, searchArg int, fuzzy bool) ([][2]string, bool) {
+	switch orderBy {
+	case "rating":
+		return [][2]string{{"COALESCE(r.rating, 0)", "FLOAT8"}, {"r.cnt", "BIGINT"}, {"p.id", "UUID"}}, true
+	case "cheap":
+		return [][2]string{{"COALESCE(s.price, p.price)", "DECIMAL"}, {"p.id", "UUID"}}, false
+	case "expensive":
+		return [][2]string{{"COALESCE(s.price, p.price)", "DECIMAL"}, {"p.id", "UUID"}}, true
+	case "relevance":
+		return [][2]string{
+			{searchRank(searchArg, fuzzy), "FLOAT8"}, {"COALESCE(r.rating, 0)", "FLOAT8"}, {"p.id", "UUID"},
+		}, true
+	default:
+		return [][2]string{{"p.created_at", "TIMESTAMPTZ"}, {"p.id", "UUID"}}, true
+	}
+}
+
+// ProductCursorKeys returns the kinds of the cursor keys of each ordering.
+func ProductCursorKeys(orderBy string) []CursorKey {
+	switch orderBy {
+	case "rating":
+		return []CursorKey{CURSOR_NUMBER, CURSOR_INTEGER}
+	case "relevance":
+		return []CursorKey{CURSOR_NUMBER, CURSOR_NUMBER}
+	case "cheap", "expensive":
+		return []CursorKey{CURSOR_INTEGER}
+	default:
+		return []CursorKey{CURSOR_TIME}
+	}
+}
+
+func productCursor(orderBy string, fuzzy bool, p *dbProduct) *Cursor {
+	cursor := &Cursor{OrderBy: orderBy, Fuzzy: fuzzy, Id: p.Id}
+	switch orderBy {
+	case "rating":
+		cursor.Keys = []string{cursorNumber(p.Rating), cursorInteger(p.Reviews)}
+	case "cheap", "expensive":
+		cursor.Keys = []string{cursorInteger(p.Price)}
+	case "relevance":
+		cursor.Keys = []string{cursorNumber(p.Rank), cursorNumber(p.Rating)}
+	default:
+		cursor.Keys = []string{cursorTime(p.CreatedAt)}
+	}
+	return cursor
 }
 
 func createFiltersQuery(filters map[string][]string) (string, []any) {
@@ -176,14 +328,32 @@ func createFiltersQuery(filters map[string][]string) (string, []any) {
 	return queryFilters, args
 }
 
-func GetProducts(request *ProductsRequest) ([]Product, error) {
+// GetProducts returns a page of products and, in keyset mode, the cursor of
+// the next page if there is one.
+func GetProducts(request *ProductsRequest) ([]Product, *Cursor, error) {
 	tmpl := template.Must(template.New("productsQuery").Funcs(template.FuncMap{
 		"inc": func(n int) int {
 			return n + 1
 		},
+		"add": func(n int, m ...int) int {
+			for _, v := range m {
+				n += v
+			}
+			return n
+		},
+		"inCategory":    categoryCondition,
+		"matchesSearch": searchCondition,
+		"searchRank":    searchRank,
+		"snippet":       searchSnippet,
+		"keyset": func(n int, orderBy string, searchArg int, fuzzy bool) string {
+			columns, desc := productCursorColumns(orderBy, searchArg, fuzzy)
+			return keysetCondition(n, desc, columns...)
+		},
 	}).Parse(`
 		{{$arg_counter:=.Cnt}}
-		SELECT p.id, p.slug, p.price,
+		{{$search_arg:=inc .Cnt}}
+		SELECT p.id, p.created_at, p.slug, COALESCE(s.price, p.price) AS price,
+			CASE WHEN s.price < p.price THEN p.price END AS old_price, p.stock, p.status,
 			json_agg(DISTINCT jsonb_build_object(
 				'id', pi.id, 'imageUrl', pi.image_url, 'width', pi.width, 'height', pi.height
 			)) AS images,
@@ -196,13 +366,20 @@ func GetProducts(request *ProductsRequest) ([]Product, error) {
 				) as translations
 			{{else}}
 				pt.title, pt.description
+				{{if .Search}}
+					, {{snippet $search_arg}} AS snippet
+				{{end}}
 			{{end}},
 			COALESCE(r.rating, 0) AS rating, r.cnt AS reviews
+			{{if .Search}}
+				, {{searchRank $search_arg .Fuzzy}} AS rank
+			{{end}}
 		{{if .Filters}}
 			FROM filtered_products AS p
 		{{else}}
 			FROM products AS p
 		{{end}}
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
 		LEFT JOIN product_translations AS pt ON p.id = pt.product_id
 		{{if not .WithTranslations}}
 			AND pt.lang = ${{$arg_counter}}
@@ -213,14 +390,17 @@ func GetProducts(request *ProductsRequest) ([]Product, error) {
 		LEFT JOIN translation_items AS fti ON fv.variant_translation_item = fti.id
 		LEFT JOIN translations AS ft ON fti.id = ft.item_id AND ft.lang = ${{$arg_counter}}
 		{{$arg_counter = inc $arg_counter}}
+		{{if .Search}}
+			{{$arg_counter = inc $arg_counter}}
+		{{end}}
 		LEFT JOIN LATERAL (
-			SELECT COUNT(*) AS cnt, AVG(rating) AS rating
+			SELECT COUNT(*) AS cnt, AVG(rating)::FLOAT8 AS rating
 			FROM reviews
 			WHERE product_id = p.id
 		) r ON TRUE
 		WHERE TRUE
 		{{if .CategoryId}}
-			AND p.category_id = ${{$arg_counter}}
+			AND {{inCategory $arg_counter .WithSubcategories}}
 			{{$arg_counter = inc $arg_counter}}
 		{{end}}
 		{{if .Ids}}
@@ -228,25 +408,43 @@ func GetProducts(request *ProductsRequest) ([]Product, error) {
 			{{$arg_counter = inc $arg_counter}}
 		{{end}}
 		{{if .Search}}
-			AND plainto_tsquery(${{.Cnt}}::TEXT::REGCONFIG, ${{$arg_counter}}) @@ pt.search
+			AND {{matchesSearch $search_arg .Fuzzy}}
+		{{end}}
+		AND p.status = ANY(${{$arg_counter}}::product_status[])
+		{{$arg_counter = inc $arg_counter}}
+		{{if .MinPrice}}
+			AND COALESCE(s.price, p.price) >= ${{$arg_counter}}
+			{{$arg_counter = inc $arg_counter}}
+		{{end}}
+		{{if .MaxPrice}}
+			AND COALESCE(s.price, p.price) <= ${{$arg_counter}}
 			{{$arg_counter = inc $arg_counter}}
 		{{end}}
-		GROUP BY p.id, r.rating, r.cnt
+		{{if .MinRating}}
+			AND COALESCE(r.rating, 0) >= ${{$arg_counter}}
+			{{$arg_counter = inc $arg_counter}}
+		{{end}}
+		{{if .Cursor}}
+			AND {{keyset $arg_counter .OrderBy $search_arg .Fuzzy}}
+			{{$arg_counter = add $arg_counter (len .Cursor.Keys) 1}}
+		{{end}}
+		GROUP BY p.id, p.created_at, r.rating, r.cnt, s.price
 		{{if .Filters}}
-			, p.slug, p.price
+			, p.slug, p.price, p.stock, p.status
 		{{end}}
 		{{if not .WithTranslations}}
 			, pt.title, pt.description
 		{{end}}
 		{{if eq .OrderBy "new"}}
-			, p.created_at
-			ORDER BY p.created_at DESC
+			ORDER BY p.created_at DESC, p.id DESC
 		{{else if eq .OrderBy "rating"}}
-			ORDER BY rating DESC, reviews DESC
+			ORDER BY rating DESC, reviews DESC, p.id DESC
 		{{else if eq .OrderBy "cheap"}}
-			ORDER BY p.price ASC
+			ORDER BY price ASC, p.id ASC
 		{{else if eq .OrderBy "expensive"}}
-			ORDER BY p.price DESC	
+			ORDER BY price DESC, p.id DESC
+		{{else if and (eq .OrderBy "relevance") .Search}}
+			ORDER BY rank DESC, rating DESC, p.id DESC
 		{{end}}
 		LIMIT ${{$arg_counter}}
 		{{$arg_counter = inc $arg_counter}}
@@ -260,6 +458,9 @@ func GetProducts(request *ProductsRequest) ([]Product, error) {
 	}
 	args = append(args, request.Lang)
 	request.Cnt = len(args)
+	if request.Search != "" {
+		args = append(args, request.Search)
+	}
 
 	if request.CategoryId != "" {
 		args = append(args, request.CategoryId)
@@ -267,23 +468,26 @@ func GetProducts(request *ProductsRequest) ([]Product, error) {
 	if len(request.Ids) > 0 {
 		args = append(args, request.Ids)
 	}
-	if request.Search != "" {
-		args = append(args, request.Search)
+	args = append(args, request.statuses())
+	args = append(args, request.boundsArgs()...)
+	if request.Cursor != nil {
+		args = append(args, cursorArgs(request.Cursor)...)
 	}
 
-	offset := 0
-	limit := pgtype.Int4{Int32: PRODUCTS_PER_PAGE}
-	if request.Page > 0 {
-		offset = (request.Page - 1) * PRODUCTS_PER_PAGE
-		limit.Valid = true
-	}
+	limit, offset := request.limitOffset(PRODUCTS_PER_PAGE)
 	args = append(args, limit, offset)
 
 	query := filtersQuery + ExecuteTemplate(tmpl, request)
 	products := make([]dbProduct, 0)
 	err := pgxscan.Select(db.Ctx, db.Client, &products, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	products, hasMore := trimPage(products, &request.Pagination, PRODUCTS_PER_PAGE)
+	if hasMore {
+		next = productCursor(request.OrderBy, request.Fuzzy, &products[len(products)-1])
 	}
 
 	result := make([]Product, len(products))
@@ -303,7 +507,7 @@ func GetProducts(request *ProductsRequest) ([]Product, error) {
 		}
 	}
 
-	return result, err
+	return result, next, nil
 }
 
 type ProductsTotal struct {
@@ -317,6 +521,8 @@ func GetTotalProducts(request *ProductsRequest) (*ProductsTotal, error) {
 		"inc": func(n int) int {
 			return n + 1
 		},
+		"inCategory":    categoryCondition,
+		"matchesSearch": searchCondition,
 	}).Parse(`
 		{{$arg_counter:=.Cnt}}
 		SELECT COUNT(*) 
@@ -325,17 +531,37 @@ func GetTotalProducts(request *ProductsRequest) (*ProductsTotal, error) {
 		{{else}}
 			FROM products AS p
 		{{end}}
-		{{if .Search}}
-			LEFT JOIN product_translations AS pt ON p.id = pt.product_id AND lang = ${{$arg_counter}}
-			{{$arg_counter = inc $arg_counter}}
+		{{if or .MinPrice .MaxPrice}}
+			LEFT JOIN active_sales AS s ON p.id = s.product_id
+		{{end}}
+		{{if .MinRating}}
+			LEFT JOIN LATERAL (
+				SELECT AVG(rating) AS rating
+				FROM reviews
+				WHERE product_id = p.id
+			) r ON TRUE
 		{{end}}
 		WHERE TRUE
 			{{if .Search}}
-				AND plainto_tsquery(${{.Cnt}}::TEXT::REGCONFIG, ${{$arg_counter}}) @@ pt.search
+				AND {{matchesSearch $arg_counter .Fuzzy}}
 				{{$arg_counter = inc $arg_counter}}
 			{{end}}
 			{{if .CategoryId}}
-				AND p.category_id = ${{$arg_counter}}
+				AND {{inCategory $arg_counter .WithSubcategories}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			AND p.status = ANY(${{$arg_counter}}::product_status[])
+			{{$arg_counter = inc $arg_counter}}
+			{{if .MinPrice}}
+				AND COALESCE(s.price, p.price) >= ${{$arg_counter}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			{{if .MaxPrice}}
+				AND COALESCE(s.price, p.price) <= ${{$arg_counter}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			{{if .MinRating}}
+				AND COALESCE(r.rating, 0) >= ${{$arg_counter}}
 				{{$arg_counter = inc $arg_counter}}
 			{{end}}
 		;
@@ -349,11 +575,13 @@ func GetTotalProducts(request *ProductsRequest) (*ProductsTotal, error) {
 
 	request.Cnt = len(args) + 1
 	if request.Search != "" {
-		args = append(args, request.Lang, request.Search)
+		args = append(args, request.Search)
 	}
 	if request.CategoryId != "" {
 		args = append(args, request.CategoryId)
 	}
+	args = append(args, request.statuses())
+	args = append(args, request.boundsArgs()...)
 	query := filtersQuery + ExecuteTemplate(tmpl, request)
 
 	var total uint64
@@ -362,10 +590,158 @@ func GetTotalProducts(request *ProductsRequest) (*ProductsTotal, error) {
 		return nil, err
 	}
 
-	hasMore := total > uint64(request.Page)*uint64(PRODUCTS_PER_PAGE)
-	totalPages := (total + PRODUCTS_PER_PAGE - 1) / PRODUCTS_PER_PAGE
+	return newProductsTotal(total, &request.Pagination), nil
+}
+
+func newProductsTotal(total uint64, p *Pagination) *ProductsTotal {
+	size := uint64(p.PageSize(PRODUCTS_PER_PAGE))
+	hasMore := total > uint64(p.Page)*size
+	totalPages := (total + size - 1) / size
+
+	return &ProductsTotal{HasMore: hasMore, Total: total, TotalPages: totalPages}
+}
+
+type facetsRequest struct {
+	*ProductsRequest
+	Exclude bool
+	Slugs   []string
+}
+
+// GetFacetCounts returns how many products match each filter variant under
+// the request's selection. Counts of a selected filter ignore its own
+// selection, so picking a variant doesn't hide the alternatives to it.
+func GetFacetCounts(request *ProductsRequest) (map[string]uint64, error) {
+	tmpl := template.Must(template.New("facetsQuery").Funcs(template.FuncMap{
+		"inc": func(n int) int {
+			return n + 1
+		},
+		"inCategory":    categoryCondition,
+		"matchesSearch": searchCondition,
+	}).Parse(`
+		{{$arg_counter:=.Cnt}}
+		SELECT fv.id, COUNT(DISTINCT p.id) AS cnt
+		{{if .Filters}}
+			FROM filtered_products AS p
+		{{else}}
+			FROM products AS p
+		{{end}}
+		JOIN product_filters AS pf ON p.id = pf.product_id
+		JOIN filter_variants AS fv ON pf.variant_id = fv.id
+		JOIN filters AS f ON fv.filter_id = f.id
+		{{if or .MinPrice .MaxPrice}}
+			LEFT JOIN active_sales AS s ON p.id = s.product_id
+		{{end}}
+		{{if .MinRating}}
+			LEFT JOIN LATERAL (
+				SELECT AVG(rating) AS rating
+				FROM reviews
+				WHERE product_id = p.id
+			) r ON TRUE
+		{{end}}
+		WHERE TRUE
+			{{if .Search}}
+				AND {{matchesSearch $arg_counter .Fuzzy}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			{{if .CategoryId}}
+				AND {{inCategory $arg_counter .WithSubcategories}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			AND p.status = ANY(${{$arg_counter}}::product_status[])
+			{{$arg_counter = inc $arg_counter}}
+			{{if .MinPrice}}
+				AND COALESCE(s.price, p.price) >= ${{$arg_counter}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			{{if .MaxPrice}}
+				AND COALESCE(s.price, p.price) <= ${{$arg_counter}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			{{if .MinRating}}
+				AND COALESCE(r.rating, 0) >= ${{$arg_counter}}
+				{{$arg_counter = inc $arg_counter}}
+			{{end}}
+			{{if .Exclude}}
+				AND f.slug <> ALL(${{$arg_counter}}::varchar[])
+			{{else}}
+				AND f.slug = ANY(${{$arg_counter}}::varchar[])
+			{{end}}
+		GROUP BY fv.id;
+	`))
+
+	selected := make([]string, 0, len(request.Filters))
+	for k := range request.Filters {
+		selected = append(selected, k)
+	}
+
+	// Variants of unselected filters are counted against the whole selection,
+	// variants of every selected filter against the rest of it.
+	groups := []facetsRequest{{request, true, selected}}
+	for _, slug := range selected {
+		others := make(map[string][]string, len(request.Filters)-1)
+		for k, v := range request.Filters {
+			if k != slug {
+				others[k] = v
+			}
+		}
+		sub := *request
+		sub.Filters = others
+		groups = append(groups, facetsRequest{&sub, false, []string{slug}})
+	}
+
+	result := make(map[string]uint64)
+	for _, group := range groups {
+		args := make([]any, 0)
+		filtersQuery := ""
+		if len(group.Filters) != 0 {
+			filtersQuery, args = createFiltersQuery(group.Filters)
+		}
+
+		group.Cnt = len(args) + 1
+		if group.Search != "" {
+			args = append(args, group.Search)
+		}
+		if group.CategoryId != "" {
+			args = append(args, group.CategoryId)
+		}
+		args = append(args, group.statuses())
+		args = append(args, group.boundsArgs()...)
+		args = append(args, group.Slugs)
+
+		query := filtersQuery + ExecuteTemplate(tmpl, group)
+		counts := make([]struct {
+			Id  string
+			Cnt uint64
+		}, 0)
+		if err := pgxscan.Select(db.Ctx, db.Client, &counts, query, args...); err != nil {
+			return nil, err
+		}
+		for _, v := range counts {
+			result[v.Id] = v.Cnt
+		}
+	}
+
+	return result, nil
+}
 
-	return &ProductsTotal{HasMore: hasMore, Total: total, TotalPages: totalPages}, nil
+type PriceRange struct {
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+}
+
+// GetPriceRange returns the lowest and highest current price in a category,
+// regardless of any other selection, for drawing a price slider.
+func GetPriceRange(request *ProductsRequest) (*PriceRange, error) {
+	result := new(PriceRange)
+	err := pgxscan.Get(db.Ctx, db.Client, result, `
+		SELECT COALESCE(MIN(COALESCE(s.price, p.price)), 0) AS min,
+			COALESCE(MAX(COALESCE(s.price, p.price)), 0) AS max
+		FROM products AS p
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
+		WHERE `+categoryCondition(1, request.WithSubcategories)+`
+			AND p.status = ANY($2::product_status[]);
+	`, request.CategoryId, request.statuses())
+	return result, err
 }
 
 type TChangeProduct struct {
@@ -373,23 +749,46 @@ type TChangeProduct struct {
 	Id string `json:"id" validate:"required"`
 }
 
-func ChangeProduct(p *TChangeProduct) error {
+func ChangeProduct(p *TChangeProduct, adminId string) error {
 	tx, err := db.Client.Begin(db.Ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(db.Ctx)
 
-	_, err = tx.Exec(db.Ctx, `
+	if err := changeProduct(&tx, p, adminId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(db.Ctx); err != nil {
+		return err
+	}
+
+	reindexProduct(p.Id)
+	return nil
+}
+
+func changeProduct(tx *pgx.Tx, p *TChangeProduct, adminId string) error {
+	if err := recordPriceChange(tx, p.Id, p.Price, adminId); err != nil {
+		return err
+	}
+
+	// stock is only overwritten when it's sent, the admin form doesn't send
+	// it and adjusts it with ChangeStock so reservations aren't lost
+	_, err := (*tx).Exec(db.Ctx, `
 		UPDATE products 
-		SET slug = $1, price = $2
-		WHERE id = $3;
-	`, p.Slug, p.Price, p.Id)
+		SET slug = $1, price = $2,
+			stock = CASE
+				WHEN $3::INT IS NULL OR EXISTS(SELECT 1 FROM product_skus WHERE product_id = $4) THEN stock
+				ELSE $3
+			END
+		WHERE id = $4;
+	`, p.Slug, p.Price, p.Stock, p.Id)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(db.Ctx, `
+	_, err = (*tx).Exec(db.Ctx, `
 		UPDATE product_translations 
 		SET title = $1, description = $2
 		WHERE product_id = $3 AND lang = $4;
@@ -398,7 +797,7 @@ func ChangeProduct(p *TChangeProduct) error {
 		return err
 	}
 
-	_, err = tx.Exec(db.Ctx, `
+	_, err = (*tx).Exec(db.Ctx, `
 		UPDATE product_translations 
 		SET title = $1, description = $2
 		WHERE product_id = $3 AND lang = $4;
@@ -407,7 +806,7 @@ func ChangeProduct(p *TChangeProduct) error {
 		return err
 	}
 
-	_, err = tx.Exec(db.Ctx, `
+	_, err = (*tx).Exec(db.Ctx, `
 		DELETE FROM product_filters
 		WHERE product_id = $1;
 	`, p.Id)
@@ -415,7 +814,7 @@ func ChangeProduct(p *TChangeProduct) error {
 		return err
 	}
 
-	_, err = tx.Exec(db.Ctx, `
+	_, err = (*tx).Exec(db.Ctx, `
 		DELETE FROM product_images
 		WHERE product_id = $1;
 	`, p.Id)
@@ -423,22 +822,72 @@ func ChangeProduct(p *TChangeProduct) error {
 		return err
 	}
 
-	if err := addProductFilters(&tx, p.Id, p.Filters); err != nil {
+	if err := addProductFilters(tx, p.Id, p.Filters); err != nil {
 		return err
 	}
 
-	if err := addProductImages(&tx, p.Id, p.Images); err != nil {
+	if err := addProductImages(tx, p.Id, p.Images); err != nil {
 		return err
 	}
 
-	return tx.Commit(db.Ctx)
+	return nil
+}
+
+func ChangeStock(id string, delta int) (int, error) {
+	hasSkus, err := productHasSkus(id)
+	if err != nil {
+		return 0, err
+	}
+	if hasSkus {
+		return 0, ErrProductHasSkus
+	}
+
+	var stock int
+	err = pgxscan.Get(db.Ctx, db.Client, &stock, `
+		UPDATE products SET stock = stock + $1
+		WHERE id = $2 AND stock + $1 >= 0
+		RETURNING stock;
+	`, delta, id)
+	if pgxscan.NotFound(err) {
+		return 0, ErrOutOfStock
+	}
+	return stock, err
 }
 
+// DeleteProduct archives the product instead of removing it, so orders and
+// reviews keep pointing to it.
 func DeleteProduct(id string) error {
-	_, err := db.Client.Exec(db.Ctx, `
-		DELETE FROM products WHERE id = $1;
-	`, id)
-	return err
+	return ChangeProductStatus(id, PRODUCT_ARCHIVED)
+}
+
+func ChangeProductStatus(id string, status ProductStatus) error {
+	tag, err := db.Client.Exec(db.Ctx, `
+		UPDATE products
+		SET status = $1,
+			archived_at = CASE WHEN $1 = 'archived' THEN COALESCE(archived_at, NOW()) END
+		WHERE id = $2;
+	`, status, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrProductNotFound
+	}
+
+	reindexProduct(id)
+	return nil
+}
+
+// sortProductsByIds puts the products in the order of ids, GetProducts
+// doesn't keep it.
+func sortProductsByIds(products []Product, ids []string) {
+	position := make(map[string]int, len(ids))
+	for k, id := range ids {
+		position[id] = k
+	}
+	sort.Slice(products, func(a, b int) bool {
+		return position[products[a].Id] < position[products[b].Id]
+	})
 }
 
 func GetPopularProducts(category string, lang Language) ([]Product, error) {
@@ -459,7 +908,8 @@ func GetPopularProducts(category string, lang Language) ([]Product, error) {
 			JOIN CategoryHierarchy AS ch ON c.parent_id = ch.id
 		)
 
-		SELECT p.id, p.slug, p.price, pt.title, pt.description,
+		SELECT p.id, p.slug, COALESCE(s.price, p.price) AS price,
+			CASE WHEN s.price < p.price THEN p.price END AS old_price, p.stock, pt.title, pt.description,
 			json_agg(DISTINCT jsonb_build_object(
 				'id', pi.id, 'imageUrl', pi.image_url, 'width', pi.width, 'height', pi.height
 			)) AS images,
@@ -467,6 +917,7 @@ func GetPopularProducts(category string, lang Language) ([]Product, error) {
 			COALESCE(r.rating, 0) AS rating, r.cnt AS reviews
 		FROM products AS p
 		JOIN CategoryHierarchy AS c ON p.category_id = c.id
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
 		LEFT JOIN product_translations AS pt ON p.id = pt.product_id AND pt.lang = $1
 		LEFT JOIN product_images AS pi ON p.id = pi.product_id
 		LEFT JOIN order_content AS o ON p.id = o.product_id
@@ -475,7 +926,8 @@ func GetPopularProducts(category string, lang Language) ([]Product, error) {
 			FROM reviews
 			WHERE product_id = p.id
 		) r ON TRUE
-		GROUP BY p.id, pt.title, pt.description, r.rating, r.cnt
+		WHERE p.status = 'published'
+		GROUP BY p.id, pt.title, pt.description, r.rating, r.cnt, s.price
 		ORDER BY popularity DESC, rating DESC
 		LIMIT 12;
 	`))
@@ -491,10 +943,11 @@ func GetPopularProducts(category string, lang Language) ([]Product, error) {
 	return result, err
 }
 
-func GetProductBySlug(slug string, lang Language) (*Product, error) {
+func GetProductBySlug(slug string, lang Language, withUnpublished bool) (*Product, error) {
 	var product Product
 	err := pgxscan.Get(db.Ctx, db.Client, &product, `
-		SELECT p.id, p.slug, p.price, pt.title, pt.description,
+		SELECT p.id, p.slug, COALESCE(s.price, p.price) AS price,
+			CASE WHEN s.price < p.price THEN p.price END AS old_price, p.stock, p.status, pt.title, pt.description,
 		json_agg(DISTINCT jsonb_build_object(
 			'id', pi.id, 'imageUrl', pi.image_url, 'width', pi.width, 'height', pi.height
 		)) AS images,
@@ -504,6 +957,7 @@ func GetProductBySlug(slug string, lang Language) (*Product, error) {
 		)) AS filters,
 		COALESCE(r.rating, 0) AS rating, r.cnt AS reviews
 		FROM products AS p
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
 		LEFT JOIN product_translations AS pt ON p.id = pt.product_id AND pt.lang = $1
 		LEFT JOIN product_images AS pi ON p.id = pi.product_id
 		LEFT JOIN product_filters AS pf ON p.id = pf.product_id
@@ -518,24 +972,32 @@ func GetProductBySlug(slug string, lang Language) (*Product, error) {
 			FROM reviews
 			WHERE product_id = p.id
 		) r ON TRUE
-		WHERE p.slug = $2
-		GROUP BY p.id, pt.title, pt.description, r.rating, r.cnt;
-	`, lang, slug)
+		WHERE p.slug = $2 AND (p.status = 'published' OR $3)
+		GROUP BY p.id, pt.title, pt.description, r.rating, r.cnt, s.price;
+	`, lang, slug, withUnpublished)
 	if pgxscan.NotFound(err) {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	product.Skus, err = GetProductSkus(product.Id)
 	return &product, err
 }
 
-func GetProductRoute(slug string, lang Language) ([]CategoryRoute, error) {
+func GetProductRoute(slug string, lang Language, withUnpublished bool) ([]CategoryRoute, error) {
 	var product struct{ Title, Category string }
 	err := pgxscan.Get(db.Ctx, db.Client, &product, `
 		SELECT pt.title, c.slug as category 
 		FROM products AS p
 		LEFT JOIN product_translations AS pt ON p.id = pt.product_id AND lang = $1
 		LEFT JOIN categories AS c ON p.category_id = c.id
-		WHERE p.slug = $2
-	`, lang, slug)
+		WHERE p.slug = $2 AND (p.status = 'published' OR $3)
+	`, lang, slug, withUnpublished)
+	if pgxscan.NotFound(err) {
+		return nil, ErrProductNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -549,7 +1011,8 @@ func GetProductRoute(slug string, lang Language) ([]CategoryRoute, error) {
 func GetRecentProducts(location *string, lang Language) ([]Product, error) {
 	result := make([]Product, 0)
 	tmpl := template.Must(template.New("recentProducts").Parse(`
-		SELECT p.id, p.slug, p.price, pt.title, '' AS description,
+		SELECT p.id, p.slug, COALESCE(s.price, p.price) AS price,
+			CASE WHEN s.price < p.price THEN p.price END AS old_price, p.stock, pt.title, '' AS description,
 			json_agg(DISTINCT jsonb_build_object(
 				'id', pi.id, 'imageUrl', pi.image_url, 'width', pi.width, 'height', pi.height
 			)) AS images,
@@ -564,6 +1027,7 @@ func GetRecentProducts(location *string, lang Language) ([]Product, error) {
 			ORDER BY c.product_id, o.created_at DESC
 		) c
 		INNER JOIN products AS p ON c.product_id = p.id
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
 		LEFT JOIN product_translations AS pt ON p.id = pt.product_id AND lang = $1
 		LEFT JOIN product_images AS pi ON p.id = pi.product_id
 		LEFT JOIN LATERAL (
@@ -571,7 +1035,8 @@ func GetRecentProducts(location *string, lang Language) ([]Product, error) {
 			FROM reviews
 			WHERE product_id = p.id
 		) r ON TRUE
-		GROUP BY p.id, p.slug, p.price, pt.title, pt.description, r.rating, r.cnt, c.created_at
+		WHERE p.status = 'published'
+		GROUP BY p.id, p.slug, p.price, p.stock, pt.title, pt.description, r.rating, r.cnt, c.created_at, s.price
 		ORDER BY c.created_at DESC
 		LIMIT 12;
 	`))
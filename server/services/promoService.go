@@ -0,0 +1,295 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+)
+
+var ErrInvalidPromoCode = errors.New("promo code is invalid or expired")
+var ErrPromoCodeLimit = errors.New("promo code usage limit reached")
+var ErrPromoCodeNotApplicable = errors.New("promo code is not applicable to this order")
+var ErrInvalidDiscount = errors.New("percentage discount cannot exceed 100")
+var ErrInvalidPeriod = errors.New("promo code must end after it starts")
+
+type DiscountType string
+
+const (
+	DISCOUNT_PERCENTAGE DiscountType = "percentage"
+	DISCOUNT_FIXED      DiscountType = "fixed"
+)
+
+type NewPromoCode struct {
+	Code           string       `json:"code" validate:"required,max=64" mod:"trim,ucase"`
+	DiscountType   DiscountType `json:"discountType" validate:"required,oneof=percentage fixed" mod:"trim"`
+	Value          uint64       `json:"value" validate:"required,min=1"`
+	MinOrderTotal  uint64       `json:"minOrderTotal"`
+	StartsAt       *time.Time   `json:"startsAt"`
+	EndsAt         *time.Time   `json:"endsAt"`
+	MaxUses        *int         `json:"maxUses" validate:"omitempty,min=1"`
+	MaxUsesPerUser *int         `json:"maxUsesPerUser" validate:"omitempty,min=1"`
+	IsActive       bool         `json:"isActive"`
+	Categories     []string     `json:"categories" validate:"max=100,dive,uuid"`
+}
+
+type PromoCode struct {
+	Id        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	NewPromoCode
+	Uses int `json:"uses"`
+}
+
+func GetPromoCodes() ([]PromoCode, error) {
+	result := make([]PromoCode, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT p.id, p.created_at, p.code, p.discount_type, p.value, p.min_order_total,
+			p.starts_at, p.ends_at, p.max_uses, p.max_uses_per_user, p.is_active,
+			ARRAY(
+				SELECT pc.category_id FROM promo_code_categories AS pc
+				WHERE pc.promo_code_id = p.id
+			) AS categories,
+			(
+				SELECT COUNT(*) FROM orders AS o
+				WHERE o.promo_code_id = p.id AND o.status != ALL($1::order_status[])
+			) AS uses
+		FROM promo_codes AS p
+		ORDER BY p.created_at DESC;
+	`, []OrderStatus{ORDER_CANCELED, ORDER_EXPIRED})
+	return result, err
+}
+
+func setPromoCodeCategories(tx *pgx.Tx, id string, categories []string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		DELETE FROM promo_code_categories WHERE promo_code_id = $1;
+	`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		INSERT INTO promo_code_categories (promo_code_id, category_id)
+		SELECT $1, UNNEST($2::uuid[])
+		ON CONFLICT DO NOTHING;
+	`, id, categories)
+	return err
+}
+
+// check validates what the struct tags can't. The period is only checked
+// when both of its ends are set, either one alone is fine.
+func (p *NewPromoCode) check() error {
+	if p.DiscountType == DISCOUNT_PERCENTAGE && p.Value > 100 {
+		return ErrInvalidDiscount
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return ErrInvalidPeriod
+	}
+	return nil
+}
+
+func CreatePromoCode(p *NewPromoCode) (string, error) {
+	if err := p.check(); err != nil {
+		return "", err
+	}
+
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	var id string
+	err = pgxscan.Get(db.Ctx, tx, &id, `
+		INSERT INTO promo_codes
+			(code, discount_type, value, min_order_total, starts_at, ends_at, max_uses, max_uses_per_user, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id;
+	`, p.Code, p.DiscountType, p.Value, p.MinOrderTotal, p.StartsAt, p.EndsAt, p.MaxUses, p.MaxUsesPerUser, p.IsActive)
+	if err != nil {
+		return "", err
+	}
+
+	if err := setPromoCodeCategories(&tx, id, p.Categories); err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit(db.Ctx)
+}
+
+type TChangePromoCode struct {
+	NewPromoCode
+	Id string `json:"id" validate:"required,uuid" mod:"trim"`
+}
+
+func ChangePromoCode(p *TChangePromoCode) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	_, err = tx.Exec(db.Ctx, `
+		UPDATE promo_codes
+		SET code = $1, discount_type = $2, value = $3, min_order_total = $4, starts_at = $5,
+			ends_at = $6, max_uses = $7, max_uses_per_user = $8, is_active = $9
+		WHERE id = $10;
+	`, p.Code, p.DiscountType, p.Value, p.MinOrderTotal, p.StartsAt, p.EndsAt, p.MaxUses, p.MaxUsesPerUser, p.IsActive, p.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := setPromoCodeCategories(&tx, p.Id, p.Categories); err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+func DeletePromoCode(id string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		DELETE FROM promo_codes WHERE id = $1;
+	`, id)
+	return err
+}
+
+type appliedPromoCode struct {
+	Id             string
+	DiscountType   DiscountType
+	Value          uint64
+	MinOrderTotal  uint64
+	MaxUses        *int
+	MaxUsesPerUser *int
+	Uses           int
+	UserUses       int
+	Restricted     bool
+}
+
+// applyPromoCode validates the code against the order lines, spreads the
+// discount over the eligible lines proportionally to their totals and stores
+// it on the order. The promo code row stays locked until the transaction
+// ends, so concurrent orders can't exceed the usage limits.
+func applyPromoCode(tx *pgx.Tx, code, userId, orderId string, lines []OrderLine) (uint64, error) {
+	var promo appliedPromoCode
+	err := pgxscan.Get(db.Ctx, *tx, &promo, `
+		SELECT p.id, p.discount_type, p.value, p.min_order_total, p.max_uses, p.max_uses_per_user,
+			(
+				SELECT COUNT(*) FROM orders AS o
+				WHERE o.promo_code_id = p.id AND o.status != ALL($3::order_status[])
+			) AS uses,
+			(
+				SELECT COUNT(*) FROM orders AS o
+				WHERE o.promo_code_id = p.id AND o.status != ALL($3::order_status[]) AND o.user_id = $2
+			) AS user_uses,
+			EXISTS(SELECT 1 FROM promo_code_categories AS pc WHERE pc.promo_code_id = p.id) AS restricted
+		FROM promo_codes AS p
+		WHERE p.code = $1 AND p.is_active
+			AND (p.starts_at IS NULL OR p.starts_at <= NOW())
+			AND (p.ends_at IS NULL OR p.ends_at > NOW())
+		FOR UPDATE OF p;
+	`, code, userId, []OrderStatus{ORDER_CANCELED, ORDER_EXPIRED})
+	if pgxscan.NotFound(err) {
+		return 0, ErrInvalidPromoCode
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if (promo.MaxUses != nil && promo.Uses >= *promo.MaxUses) ||
+		(promo.MaxUsesPerUser != nil && promo.UserUses >= *promo.MaxUsesPerUser) {
+		return 0, ErrPromoCodeLimit
+	}
+
+	eligible := make(map[string]bool)
+	if promo.Restricted {
+		productIds := make([]string, 0, len(lines))
+		for _, l := range lines {
+			productIds = append(productIds, *l.ProductId)
+		}
+
+		var ids []string
+		err = pgxscan.Select(db.Ctx, *tx, &ids, `
+			WITH RECURSIVE tree AS (
+				SELECT category_id AS id FROM promo_code_categories
+				WHERE promo_code_id = $1
+				UNION
+				SELECT c.id FROM categories AS c
+				INNER JOIN tree AS t ON c.parent_id = t.id
+			)
+			SELECT p.id FROM products AS p
+			WHERE p.id = ANY($2::uuid[]) AND p.category_id IN (SELECT id FROM tree);
+		`, promo.Id, productIds)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			eligible[id] = true
+		}
+	}
+
+	var total, eligibleTotal uint64
+	for _, l := range lines {
+		total += l.Price * uint64(l.Quantity)
+		if !promo.Restricted || eligible[*l.ProductId] {
+			eligibleTotal += l.Price * uint64(l.Quantity)
+		}
+	}
+	if total < promo.MinOrderTotal || eligibleTotal == 0 {
+		return 0, ErrPromoCodeNotApplicable
+	}
+
+	discount := promo.Value
+	if promo.DiscountType == DISCOUNT_PERCENTAGE {
+		discount = eligibleTotal * promo.Value / 100
+	}
+	if discount > eligibleTotal {
+		discount = eligibleTotal
+	}
+
+	left := discount
+	last := -1
+	for i, l := range lines {
+		if promo.Restricted && !eligible[*l.ProductId] {
+			continue
+		}
+		lines[i].Discount = discount * l.Price * uint64(l.Quantity) / eligibleTotal
+		left -= lines[i].Discount
+		last = i
+	}
+	lines[last].Discount += left
+
+	ids := make([]string, 0)
+	discounts := make([]uint64, 0)
+	for _, l := range lines {
+		if l.Discount > 0 {
+			ids = append(ids, l.Id)
+			discounts = append(discounts, l.Discount)
+		}
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE order_content AS c SET discount = r.discount
+		FROM (
+			SELECT UNNEST($1::uuid[]) AS id, UNNEST($2::bigint[]) AS discount
+		) AS r
+		WHERE c.id = r.id;
+	`, ids, discounts)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE orders SET promo_code_id = $1, promo_code = $2, discount = $3
+		WHERE id = $4;
+	`, promo.Id, code, discount, orderId)
+	if err != nil {
+		return 0, err
+	}
+
+	return discount, nil
+}
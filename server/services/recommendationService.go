@@ -0,0 +1,103 @@
+package services
+
+import (
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+type RecommendationType string
+
+const (
+	RECOMMENDATION_RELATED         RecommendationType = "related"
+	RECOMMENDATION_BOUGHT_TOGETHER RecommendationType = "bought_together"
+)
+
+const RECOMMENDATIONS_PER_PRODUCT = 12
+
+// RecomputeRecommendations replaces the cached recommendations of every
+// product. Related products are the published ones of the same category
+// sharing the most filter variants, bought together ones are those found in
+// the most orders with the product that were neither canceled nor left
+// unpaid until expiry.
+func RecomputeRecommendations() error {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	_, err = tx.Exec(db.Ctx, `DELETE FROM product_recommendations;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		INSERT INTO product_recommendations (product_id, type, recommended_id, score, position)
+		SELECT product_id, $1, recommended_id, score, position
+		FROM (
+			SELECT a.product_id, b.product_id AS recommended_id, COUNT(*) AS score,
+				ROW_NUMBER() OVER (
+					PARTITION BY a.product_id ORDER BY COUNT(*) DESC, b.product_id
+				) AS position
+			FROM product_filters AS a
+			INNER JOIN product_filters AS b ON a.variant_id = b.variant_id AND a.product_id <> b.product_id
+			INNER JOIN products AS pa ON a.product_id = pa.id
+			INNER JOIN products AS pb ON b.product_id = pb.id AND pa.category_id = pb.category_id
+			WHERE pb.status = 'published'
+			GROUP BY a.product_id, b.product_id
+		) AS r
+		WHERE position <= $2;
+	`, RECOMMENDATION_RELATED, RECOMMENDATIONS_PER_PRODUCT)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		INSERT INTO product_recommendations (product_id, type, recommended_id, score, position)
+		SELECT product_id, $1, recommended_id, score, position
+		FROM (
+			SELECT a.product_id, b.product_id AS recommended_id, COUNT(DISTINCT a.order_id) AS score,
+				ROW_NUMBER() OVER (
+					PARTITION BY a.product_id ORDER BY COUNT(DISTINCT a.order_id) DESC, b.product_id
+				) AS position
+			FROM order_content AS a
+			INNER JOIN order_content AS b ON a.order_id = b.order_id AND a.product_id <> b.product_id
+			INNER JOIN orders AS o ON a.order_id = o.id
+			INNER JOIN products AS pb ON b.product_id = pb.id
+			WHERE o.status <> ALL($2::order_status[]) AND pb.status = 'published'
+			GROUP BY a.product_id, b.product_id
+		) AS r
+		WHERE position <= $3;
+	`, RECOMMENDATION_BOUGHT_TOGETHER, []OrderStatus{ORDER_CANCELED, ORDER_EXPIRED}, RECOMMENDATIONS_PER_PRODUCT)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+// GetRecommendations returns the cached recommendations of the given type,
+// best first. Products created since the last recompute have none yet.
+func GetRecommendations(productId string, t RecommendationType, lang Language) ([]Product, error) {
+	ids := make([]string, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &ids, `
+		SELECT recommended_id FROM product_recommendations
+		WHERE product_id = $1 AND type = $2
+		ORDER BY position;
+	`, productId, t)
+	if err != nil || len(ids) == 0 {
+		return make([]Product, 0), err
+	}
+
+	products, _, err := GetProducts(&ProductsRequest{
+		Lang: lang,
+		Ids:  ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortProductsByIds(products, ids)
+
+	return products, nil
+}
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+	"github.com/yura4ka/vydelka/payments"
+)
+
+var ErrCantRefund = errors.New("cannot refund this order")
+
+const CUSTOMER_REFUND_PERIOD = time.Hour * 24
+
+// REFUND_RETRY_DELAY keeps the retry job away from refunds whose first
+// request may still be in flight.
+const REFUND_RETRY_DELAY = time.Minute * 5
+
+// REFUND_MAX_ATTEMPTS is how many times a refund is requested before it's
+// given up and has to be made again.
+const REFUND_MAX_ATTEMPTS = 10
+
+type RefundStatus string
+
+const (
+	REFUND_PENDING   RefundStatus = "pending"
+	REFUND_SUCCEEDED RefundStatus = "succeeded"
+	REFUND_FAILED    RefundStatus = "failed"
+)
+
+type RefundLine struct {
+	Id    string `json:"id" validate:"required,uuid" mod:"trim"`
+	Count int    `json:"count" validate:"required,min=1"`
+}
+
+type refundableOrder struct {
+	Status             OrderStatus
+	PaymentTime        *time.Time
+	PaymentProvider    *string
+	PaymentReference   *string
+	PaymentTransaction *string
+}
+
+func getPaymentTransaction(provider payments.Provider, order *refundableOrder) (string, error) {
+	if order.PaymentTransaction != nil && *order.PaymentTransaction != "" {
+		return *order.PaymentTransaction, nil
+	}
+	if order.PaymentReference == nil {
+		return "", ErrCantRefund
+	}
+
+	status, err := provider.GetStatus(*order.PaymentReference)
+	if err != nil {
+		return "", err
+	}
+	if status.Transaction == "" {
+		return "", ErrCantRefund
+	}
+	return status.Transaction, nil
+}
+
+// lineValue is the amount paid for the first count units of the line. The line
+// discount is spread evenly over its units, so refunding every unit returns
+// exactly what was paid.
+func lineValue(line *OrderLine, count int) uint64 {
+	return line.Price*uint64(count) - line.Discount*uint64(count)/uint64(line.Quantity)
+}
+
+func RefundOrder(id string, lines []RefundLine, changedBy string, ownerId *string) error {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	var order refundableOrder
+	err = pgxscan.Get(db.Ctx, tx, &order, `
+		SELECT status, payment_time, payment_provider, payment_reference, payment_transaction
+		FROM orders
+		WHERE id = $1 AND ($2::uuid IS NULL OR user_id = $2)
+		FOR UPDATE;
+	`, id, ownerId)
+	if pgxscan.NotFound(err) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if order.PaymentTime == nil || order.PaymentProvider == nil ||
+		order.Status == ORDER_CANCELED || order.Status == ORDER_EXPIRED {
+		return ErrCantRefund
+	}
+	if ownerId != nil &&
+		(order.Status == ORDER_RECEIVED || time.Since(*order.PaymentTime) > CUSTOMER_REFUND_PERIOD) {
+		return ErrCantRefund
+	}
+
+	// units of refunds the provider hasn't accepted yet count as refunded, so
+	// they can't be refunded twice
+	content := make([]OrderLine, 0)
+	err = pgxscan.Select(db.Ctx, tx, &content, `
+		SELECT c.id, c.price, c.quantity, c.discount,
+			c.refunded_quantity + COALESCE((
+				SELECT SUM(rl.count) FROM refund_lines AS rl
+				INNER JOIN refunds AS r ON rl.refund_id = r.id
+				WHERE rl.content_id = c.id AND r.status = $2
+			), 0) AS refunded_quantity
+		FROM order_content AS c
+		WHERE c.order_id = $1;
+	`, id, REFUND_PENDING)
+	if err != nil {
+		return err
+	}
+
+	requested := make(map[string]int)
+	if len(lines) == 0 {
+		for _, c := range content {
+			requested[c.Id] = c.Quantity - c.RefundedQuantity
+		}
+	}
+	for _, l := range lines {
+		requested[l.Id] += l.Count
+	}
+
+	var amount uint64
+	ids := make([]string, 0)
+	counts := make([]int, 0)
+	for _, c := range content {
+		count, ok := requested[c.Id]
+		delete(requested, c.Id)
+		if count > c.Quantity-c.RefundedQuantity {
+			return ErrCantRefund
+		}
+		if !ok || count == 0 {
+			continue
+		}
+		amount += lineValue(&c, c.RefundedQuantity+count) - lineValue(&c, c.RefundedQuantity)
+		ids = append(ids, c.Id)
+		counts = append(counts, count)
+	}
+	if len(requested) > 0 || amount == 0 {
+		return ErrCantRefund
+	}
+
+	provider, err := payments.Get(*order.PaymentProvider)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := getPaymentTransaction(provider, &order)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		UPDATE orders SET refund_status = $1, payment_transaction = $2
+		WHERE id = $3;
+	`, REFUND_PENDING, transaction, id)
+	if err != nil {
+		return err
+	}
+
+	// received goods come back through inspection, so only what was never
+	// delivered goes back on sale
+	refund := pendingRefund{
+		OrderId:            id,
+		Amount:             amount,
+		PaymentProvider:    *order.PaymentProvider,
+		PaymentTransaction: transaction,
+	}
+	err = pgxscan.Get(db.Ctx, tx, &refund.Id, `
+		INSERT INTO refunds (order_id, amount, changed_by, restock)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+	`, id, amount, changedBy, order.Status != ORDER_RECEIVED)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		INSERT INTO refund_lines (refund_id, content_id, count)
+		SELECT $1, UNNEST($2::uuid[]), UNNEST($3::int[]);
+	`, refund.Id, ids, counts)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(db.Ctx); err != nil {
+		return err
+	}
+
+	// the refund is recorded, so a failure here is left to RetryRefunds
+	if err := requestRefund(&refund); err != nil {
+		log.Printf("failed to request refund %s of order %s: %v", refund.Id, id, err)
+	}
+	return nil
+}
+
+type pendingRefund struct {
+	Id                 string
+	OrderId            string
+	Amount             uint64
+	PaymentProvider    string
+	PaymentTransaction string
+	Attempts           int
+}
+
+// requestRefund sends a recorded refund to the provider, keyed by its id so a
+// repeated request doesn't refund twice. The refund is applied to the order
+// once the provider accepts it.
+func requestRefund(r *pendingRefund) error {
+	provider, err := payments.Get(r.PaymentProvider)
+	if err != nil {
+		return err
+	}
+
+	if err := provider.Refund(r.PaymentTransaction, r.Amount, r.OrderId, r.Id); err != nil {
+		return failRefundAttempt(r, err)
+	}
+
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	if err := confirmRefund(&tx, r.Id); err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+// failRefundAttempt counts a failed request. A refund the provider rejected
+// or that failed too many times is given up, it has to be made again.
+func failRefundAttempt(r *pendingRefund, cause error) error {
+	giveUp := errors.Is(cause, payments.ErrRefundRejected) || r.Attempts+1 >= REFUND_MAX_ATTEMPTS
+
+	tag, err := db.Client.Exec(db.Ctx, `
+		UPDATE refunds
+		SET attempts = attempts + 1, status = CASE WHEN $2 THEN $3 ELSE status END
+		WHERE id = $1 AND status = $4;
+	`, r.Id, giveUp, REFUND_FAILED, REFUND_PENDING)
+	if err != nil {
+		return err
+	}
+
+	if giveUp && tag.RowsAffected() > 0 {
+		_, err = db.Client.Exec(db.Ctx, `
+			UPDATE orders SET refund_status = $1
+			WHERE id = $2;
+		`, REFUND_FAILED, r.OrderId)
+		if err != nil {
+			return err
+		}
+	}
+	return cause
+}
+
+// confirmRefund applies an accepted refund to the order: the units are marked
+// refunded, restocked if they were never delivered, and the order is
+// canceled once nothing is left in it.
+func confirmRefund(tx *pgx.Tx, refundId string) error {
+	var refund struct {
+		OrderId   string
+		Restock   bool
+		ChangedBy *string
+	}
+	err := pgxscan.Get(db.Ctx, *tx, &refund, `
+		UPDATE refunds SET status = $1, requested_at = NOW()
+		WHERE id = $2 AND status = $3
+		RETURNING order_id, restock, changed_by;
+	`, REFUND_SUCCEEDED, refundId, REFUND_PENDING)
+	if pgxscan.NotFound(err) {
+		// already applied by a concurrent retry or failed meanwhile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var status OrderStatus
+	err = pgxscan.Get(db.Ctx, *tx, &status, `
+		SELECT status FROM orders WHERE id = $1 FOR UPDATE;
+	`, refund.OrderId)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		WITH refunded AS (
+			UPDATE order_content AS c SET refunded_quantity = c.refunded_quantity + rl.count
+			FROM refund_lines AS rl
+			WHERE rl.refund_id = $1 AND c.id = rl.content_id
+			RETURNING c.product_id, c.sku_id, rl.count
+		), restocked_skus AS (
+			UPDATE product_skus AS s SET stock = s.stock + r.count
+			FROM refunded AS r
+			WHERE s.id = r.sku_id AND $2
+		)
+		UPDATE products AS p SET stock = p.stock + r.count
+		FROM refunded AS r
+		WHERE p.id = r.product_id AND r.sku_id IS NULL AND $2;
+	`, refundId, refund.Restock)
+	if err != nil {
+		return err
+	}
+
+	var remaining int
+	err = pgxscan.Get(db.Ctx, *tx, &remaining, `
+		SELECT COALESCE(SUM(quantity - refunded_quantity), 0)
+		FROM order_content
+		WHERE order_id = $1;
+	`, refund.OrderId)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 || status == ORDER_CANCELED {
+		return nil
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE orders SET status = $1
+		WHERE id = $2;
+	`, ORDER_CANCELED, refund.OrderId)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE refunds SET canceled_status = $1
+		WHERE id = $2;
+	`, status, refundId)
+	if err != nil {
+		return err
+	}
+
+	return addStatusHistory(tx, refund.OrderId, ORDER_CANCELED, refund.ChangedBy)
+}
+
+// revertRefund undoes a refund the provider accepted but failed to pay out.
+// Restocked units may have been sold meanwhile, so stock doesn't go below
+// zero, and the order gets back the status the refund canceled.
+func revertRefund(tx *pgx.Tx, refundId string) error {
+	var refund struct {
+		OrderId        string
+		Applied        bool
+		Restock        bool
+		CanceledStatus *OrderStatus
+	}
+	err := pgxscan.Get(db.Ctx, *tx, &refund, `
+		UPDATE refunds AS r SET status = $1
+		FROM (SELECT id, status FROM refunds WHERE id = $2 FOR UPDATE) AS old
+		WHERE r.id = old.id AND old.status <> $1
+		RETURNING r.order_id, old.status = $3 AS applied, r.restock, r.canceled_status;
+	`, REFUND_FAILED, refundId, REFUND_SUCCEEDED)
+	if pgxscan.NotFound(err) {
+		return nil
+	}
+	if err != nil || !refund.Applied {
+		return err
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		WITH reverted AS (
+			UPDATE order_content AS c SET refunded_quantity = c.refunded_quantity - rl.count
+			FROM refund_lines AS rl
+			WHERE rl.refund_id = $1 AND c.id = rl.content_id
+			RETURNING c.product_id, c.sku_id, rl.count
+		), unstocked_skus AS (
+			UPDATE product_skus AS s SET stock = GREATEST(s.stock - r.count, 0)
+			FROM reverted AS r
+			WHERE s.id = r.sku_id AND $2
+		)
+		UPDATE products AS p SET stock = GREATEST(p.stock - r.count, 0)
+		FROM reverted AS r
+		WHERE p.id = r.product_id AND r.sku_id IS NULL AND $2;
+	`, refundId, refund.Restock)
+	if err != nil {
+		return err
+	}
+
+	if refund.CanceledStatus == nil {
+		return nil
+	}
+
+	_, err = (*tx).Exec(db.Ctx, `
+		UPDATE orders SET status = $1
+		WHERE id = $2 AND status = $3;
+	`, *refund.CanceledStatus, refund.OrderId, ORDER_CANCELED)
+	if err != nil {
+		return err
+	}
+
+	return addStatusHistory(tx, refund.OrderId, *refund.CanceledStatus, nil)
+}
+
+// RetryRefunds sends the refunds the provider hasn't accepted yet again.
+func RetryRefunds() error {
+	var refunds []pendingRefund
+	err := pgxscan.Select(db.Ctx, db.Client, &refunds, `
+		SELECT r.id, r.order_id, r.amount, r.attempts, o.payment_provider, o.payment_transaction
+		FROM refunds AS r
+		INNER JOIN orders AS o ON r.order_id = o.id
+		WHERE r.status = $1 AND r.created_at < $2
+		ORDER BY r.created_at;
+	`, REFUND_PENDING, time.Now().Add(-REFUND_RETRY_DELAY))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refunds {
+		if err := requestRefund(&r); err != nil {
+			log.Printf("failed to request refund %s of order %s: %v", r.Id, r.OrderId, err)
+		}
+	}
+	return nil
+}
+
+// applyRefund records a refund reported by the provider. Refunds made in the
+// shop are applied by confirmRefund, so the order is only canceled here for
+// full refunds made elsewhere, e.g. in the provider dashboard.
+func applyRefund(tx *pgx.Tx, provider, transaction string, amount uint64, fullyRefunded bool) error {
+	var order struct {
+		Id      string
+		Status  OrderStatus
+		Pending bool
+	}
+	err := pgxscan.Get(db.Ctx, *tx, &order, `
+		UPDATE orders AS o
+		SET refunded_amount = $1, refund_status = $2, refunded_at = NOW()
+		WHERE payment_provider = $3 AND payment_transaction = $4
+		RETURNING id, status,
+			EXISTS(SELECT 1 FROM refunds AS r WHERE r.order_id = o.id AND r.status = $5) AS pending;
+	`, amount, REFUND_SUCCEEDED, provider, transaction, REFUND_PENDING)
+	if pgxscan.NotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if fullyRefunded && !order.Pending && order.Status != ORDER_CANCELED {
+		if order.Status != ORDER_RECEIVED {
+			if err := releaseStock(tx, order.Id); err != nil {
+				return err
+			}
+		}
+
+		_, err = (*tx).Exec(db.Ctx, `
+			UPDATE order_content SET refunded_quantity = quantity
+			WHERE order_id = $1;
+		`, order.Id)
+		if err != nil {
+			return err
+		}
+
+		_, err = (*tx).Exec(db.Ctx, `
+			UPDATE orders SET status = $1
+			WHERE id = $2;
+		`, ORDER_CANCELED, order.Id)
+		if err != nil {
+			return err
+		}
+
+		if err := addStatusHistory(tx, order.Id, ORDER_CANCELED, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failRefund records a refund the provider failed to pay out after accepting
+// it and reverts it when it's known which one it was.
+func failRefund(tx *pgx.Tx, provider, transaction, refundId string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		UPDATE orders SET refund_status = $1
+		WHERE payment_provider = $2 AND payment_transaction = $3;
+	`, REFUND_FAILED, provider, transaction)
+	if err != nil || refundId == "" {
+		return err
+	}
+	return revertRefund(tx, refundId)
+}
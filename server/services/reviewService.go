@@ -21,7 +21,10 @@ type Review struct {
 	IsVerified bool       `json:"isVerified"`
 }
 
-func GetReviews(productId string, page int) ([]Review, error) {
+func GetReviews(productId string, p *Pagination) ([]Review, *Cursor, error) {
+	createdAt, id := newestCursorArgs(p.Cursor)
+	limit, offset := p.limitOffset(REVIEWS_PER_PAGE)
+
 	reviews := make([]Review, 0)
 	err := pgxscan.Select(db.Ctx, db.Client, &reviews, `
 		SELECT r.id, r.created_at, r.updated_at, r.content, r.rating, r.product_id,
@@ -38,13 +41,24 @@ func GetReviews(productId string, page int) ([]Review, error) {
 			LIMIT 1
 		) o ON TRUE
 		WHERE r.product_id = $1
-		ORDER BY r.created_at DESC
+			AND ($4::TIMESTAMPTZ IS NULL OR (r.created_at, r.id) < ($4::TIMESTAMPTZ, $5::UUID))
+		ORDER BY r.created_at DESC, r.id DESC
 		LIMIT $2 OFFSET $3;
-	`, productId, REVIEWS_PER_PAGE, (page-1)*REVIEWS_PER_PAGE)
-	return reviews, err
+	`, productId, limit, offset, createdAt, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	reviews, hasMore := trimPage(reviews, p, REVIEWS_PER_PAGE)
+	if hasMore {
+		last := reviews[len(reviews)-1]
+		next = newestCursor(last.CreatedAt, last.Id)
+	}
+	return reviews, next, nil
 }
 
-func HasMoreReviews(productId string, page int) (bool, int, error) {
+func HasMoreReviews(productId string, p *Pagination) (bool, int, error) {
 	var total int
 	err := pgxscan.Get(db.Ctx, db.Client, &total, `
 		SELECT COUNT(*) 
@@ -52,8 +66,7 @@ func HasMoreReviews(productId string, page int) (bool, int, error) {
 		WHERE product_id = $1; 
 	`, productId)
 
-	hasMore := total > page*REVIEWS_PER_PAGE
-	totalPages := (total + REVIEWS_PER_PAGE - 1) / REVIEWS_PER_PAGE
+	hasMore, totalPages := pageCounts(total, p, REVIEWS_PER_PAGE)
 
 	return hasMore, totalPages, err
 }
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const POSTGRES_SEARCH_INDEX = "postgres"
+const MEMORY_SEARCH_INDEX = "memory"
+const SEARCH_INDEX_REBUILD_INTERVAL = time.Minute * 5
+
+// SearchIndex answers product listing and search requests. Implementations
+// that keep their own copy of the catalog are notified about every product
+// change through IndexProduct and DeleteProduct, and rebuilt periodically to
+// pick up what changes without a product mutation, like sales and reviews.
+// Query returns the cursor of the next page in keyset mode, and no total if
+// the request skips it.
+type SearchIndex interface {
+	Name() string
+	IndexProduct(id string) error
+	DeleteProduct(id string) error
+	Rebuild() error
+	Query(request *ProductsRequest) ([]Product, *Cursor, *ProductsTotal, error)
+	Facets(request *ProductsRequest) (map[string]uint64, error)
+}
+
+var searchIndex SearchIndex = &PostgresSearchIndex{}
+
+func GetSearchIndex() SearchIndex {
+	return searchIndex
+}
+
+// SetupSearchIndex picks the implementation named by SEARCH_INDEX, the
+// Postgres full-text search being the default. Every replica rebuilds its own
+// index, so this doesn't go through the jobs, which run on one replica only.
+func SetupSearchIndex() {
+	switch os.Getenv("SEARCH_INDEX") {
+	case MEMORY_SEARCH_INDEX:
+		searchIndex = NewMemorySearchIndex()
+	default:
+		searchIndex = &PostgresSearchIndex{}
+	}
+
+	if err := searchIndex.Rebuild(); err != nil {
+		log.Fatalf("failed to build %s search index: %v", searchIndex.Name(), err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(SEARCH_INDEX_REBUILD_INTERVAL)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := searchIndex.Rebuild(); err != nil {
+				log.Printf("failed to rebuild %s search index: %v", searchIndex.Name(), err)
+			}
+		}
+	}()
+}
+
+// reindexProduct is called after a product change has been committed, so a
+// failure only leaves the index stale until the next rebuild.
+func reindexProduct(id string) {
+	if err := searchIndex.IndexProduct(id); err != nil {
+		log.Printf("failed to index product %s: %v", id, err)
+	}
+}
+
+// PostgresSearchIndex queries the products tables directly, so there is
+// nothing to keep in sync.
+type PostgresSearchIndex struct{}
+
+func (i *PostgresSearchIndex) Name() string {
+	return POSTGRES_SEARCH_INDEX
+}
+
+func (i *PostgresSearchIndex) IndexProduct(id string) error {
+	return nil
+}
+
+func (i *PostgresSearchIndex) DeleteProduct(id string) error {
+	return nil
+}
+
+func (i *PostgresSearchIndex) Rebuild() error {
+	return nil
+}
+
+func (i *PostgresSearchIndex) Query(request *ProductsRequest) ([]Product, *Cursor, *ProductsTotal, error) {
+	// the following pages keep the kind of match the first one used
+	if request.Cursor != nil {
+		request.Fuzzy = request.Cursor.Fuzzy
+	}
+	retryFuzzy := request.Search != "" && request.Cursor == nil && !request.Fuzzy
+
+	var total *ProductsTotal
+	if !request.IsKeyset() || !request.SkipTotal {
+		var err error
+		total, err = GetTotalProducts(request)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		// nothing matched the words of the query, so retry with a typo-tolerant match
+		if total.Total == 0 && retryFuzzy {
+			request.Fuzzy = true
+			total, err = GetTotalProducts(request)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	products, next, err := GetProducts(request)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// without the total, an empty first page is what triggers the retry
+	if total == nil && len(products) == 0 && retryFuzzy {
+		request.Fuzzy = true
+		products, next, err = GetProducts(request)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return products, next, total, nil
+}
+
+func (i *PostgresSearchIndex) Facets(request *ProductsRequest) (map[string]uint64, error) {
+	return GetFacetCounts(request)
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+const SEARCH_REPORT_LIMIT = 50
+
+type SearchLogEntry struct {
+	Id      string
+	Query   string
+	Lang    Language
+	Results uint64
+	IsFuzzy bool
+	UserId  string
+}
+
+// LogSearch records a storefront search. It is meant to be called in its own
+// goroutine, so the id is generated by the caller and returned to the client
+// before the row exists.
+func LogSearch(entry *SearchLogEntry) error {
+	var userId *string
+	if entry.UserId != "" {
+		userId = &entry.UserId
+	}
+
+	_, err := db.Client.Exec(db.Ctx, `
+		INSERT INTO search_queries (id, query, lang, results, is_fuzzy, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`, entry.Id, entry.Query, entry.Lang, entry.Results, entry.IsFuzzy, userId)
+	return err
+}
+
+// LogSearchClick marks a search as followed by a view of one of its results.
+// Only the first click of a search is kept.
+func LogSearchClick(searchId, productId string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		UPDATE search_queries SET clicked_product_id = $2, clicked_at = NOW()
+		WHERE id = $1 AND clicked_at IS NULL;
+	`, searchId, productId)
+	return err
+}
+
+type SearchQueryStats struct {
+	Query          string    `json:"query"`
+	Searches       uint64    `json:"searches"`
+	AvgResults     float64   `json:"avgResults"`
+	Clicks         uint64    `json:"clicks"`
+	ClickThrough   float64   `json:"clickThrough"`
+	LastSearchedAt time.Time `json:"lastSearchedAt"`
+}
+
+type SearchReport struct {
+	From         time.Time          `json:"from"`
+	Searches     uint64             `json:"searches"`
+	ZeroResults  uint64             `json:"zeroResults"`
+	ClickThrough float64            `json:"clickThrough"`
+	TopQueries   []SearchQueryStats `json:"topQueries"`
+	TopNoResults []SearchQueryStats `json:"topNoResults"`
+}
+
+func getSearchQueryStats(from time.Time, onlyZeroResults bool) ([]SearchQueryStats, error) {
+	result := make([]SearchQueryStats, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT lower(trim(query)) AS query, COUNT(*) AS searches,
+			AVG(results)::FLOAT AS avg_results,
+			COUNT(clicked_at) AS clicks,
+			COUNT(clicked_at)::FLOAT / COUNT(*) AS click_through,
+			MAX(created_at) AS last_searched_at
+		FROM search_queries
+		WHERE created_at >= $1 AND (NOT $2 OR results = 0)
+		GROUP BY lower(trim(query))
+		ORDER BY searches DESC, last_searched_at DESC
+		LIMIT $3;
+	`, from, onlyZeroResults, SEARCH_REPORT_LIMIT)
+	return result, err
+}
+
+// GetSearchReport summarizes searches made since from: the most frequent
+// queries, the most frequent ones that found nothing and how often a search
+// led to a product view.
+func GetSearchReport(from time.Time) (*SearchReport, error) {
+	result := &SearchReport{From: from}
+	err := pgxscan.Get(db.Ctx, db.Client, result, `
+		SELECT COUNT(*) AS searches,
+			COUNT(*) FILTER (WHERE results = 0) AS zero_results,
+			COALESCE(COUNT(clicked_at)::FLOAT / NULLIF(COUNT(*), 0), 0) AS click_through
+		FROM search_queries
+		WHERE created_at >= $1;
+	`, from)
+	if err != nil {
+		return nil, err
+	}
+
+	result.TopQueries, err = getSearchQueryStats(from, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result.TopNoResults, err = getSearchQueryStats(from, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
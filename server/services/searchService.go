@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+const SUGGEST_MIN_LENGTH = 2
+const SUGGEST_PRODUCTS = 8
+const SUGGEST_CATEGORIES = 5
+
+var searchLanguages = []Language{Languages.En, Languages.Ua}
+
+// searchTsquery is the query passed as the n-th argument with the synonyms
+// and stop words of the language applied (see search_tsquery). Being an
+// uncorrelated subquery, it is computed once per statement rather than once
+// per row, and can be used to scan the index on search.
+func searchTsquery(n int, lang Language) string {
+	return fmt.Sprintf("(SELECT search_tsquery('%s'::language_type, $%d))", lang, n)
+}
+
+// searchTsqueryOf picks the searchTsquery of the language in langColumn.
+func searchTsqueryOf(n int, langColumn string) string {
+	cases := make([]string, len(searchLanguages))
+	for i, lang := range searchLanguages {
+		cases[i] = fmt.Sprintf("WHEN '%s' THEN %s", lang, searchTsquery(n, lang))
+	}
+	return fmt.Sprintf("CASE %s %s END", langColumn, strings.Join(cases, " "))
+}
+
+// searchCondition matches products with a translation in any language that
+// contains the query passed as the n-th argument. The fuzzy variant compares
+// trigrams of the titles, including their transliteration, instead.
+func searchCondition(n int, fuzzy bool) string {
+	if fuzzy {
+		return fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM product_translations AS st
+			WHERE st.product_id = p.id AND lower($%d) <%% st.search_title
+		)`, n)
+	}
+
+	// a branch per language keeps the right side of @@ constant
+	matches := make([]string, len(searchLanguages))
+	for i, lang := range searchLanguages {
+		matches[i] = fmt.Sprintf("(st.lang = '%s' AND st.search @@ %s)", lang, searchTsquery(n, lang))
+	}
+	return fmt.Sprintf(`EXISTS (
+		SELECT 1 FROM product_translations AS st
+		WHERE st.product_id = p.id AND (%s)
+	)`, strings.Join(matches, " OR "))
+}
+
+// searchRank scores a product against the query passed as the n-th argument
+// by its best matching translation. Title matches weigh more than description
+// ones, see generate_tsvector.
+func searchRank(n int, fuzzy bool) string {
+	if fuzzy {
+		return fmt.Sprintf(`(
+			SELECT MAX(word_similarity(lower($%d), st.search_title))
+			FROM product_translations AS st
+			WHERE st.product_id = p.id
+		)`, n)
+	}
+
+	return fmt.Sprintf(`(
+		SELECT MAX(ts_rank_cd(st.search, %s))
+		FROM product_translations AS st
+		WHERE st.product_id = p.id
+	)`, searchTsqueryOf(n, "st.lang"))
+}
+
+// searchSnippet returns the fragments of pt.description around the words of
+// the query passed as the n-th argument, with the words wrapped in <b>. The
+// description is HTML-escaped first, so the <b> tags are the only markup.
+func searchSnippet(n int) string {
+	return fmt.Sprintf(`ts_headline(
+		pt.lang::TEXT::REGCONFIG,
+		replace(replace(replace(pt.description, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		%s,
+		'StartSel=<b>, StopSel=</b>, MaxFragments=2, MaxWords=20, MinWords=8, FragmentDelimiter=" ... "'
+	)`, searchTsqueryOf(n, "pt.lang"))
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+type ProductSuggestion struct {
+	Id       string  `json:"id"`
+	Slug     string  `json:"slug"`
+	Title    string  `json:"title"`
+	ImageUrl *string `json:"imageUrl,omitempty"`
+	Category string  `json:"category"`
+}
+
+type CategorySuggestion struct {
+	Id    string `json:"id"`
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+type Suggestions struct {
+	Products   []ProductSuggestion  `json:"products"`
+	Categories []CategorySuggestion `json:"categories"`
+}
+
+// GetSuggestions returns product titles and categories for a partially typed
+// query. Words starting with the query rank first, then similar ones, so the
+// list stays useful with typos and with Ukrainian typed in Latin letters.
+func GetSuggestions(query string, lang Language) (*Suggestions, error) {
+	result := &Suggestions{
+		Products:   make([]ProductSuggestion, 0),
+		Categories: make([]CategorySuggestion, 0),
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	if len([]rune(query)) < SUGGEST_MIN_LENGTH {
+		return result, nil
+	}
+	prefix := "% " + escapeLike(query) + "%"
+
+	err := pgxscan.Select(db.Ctx, db.Client, &result.Products, `
+		SELECT p.id, p.slug, pt.title, c.slug AS category,
+			(SELECT image_url FROM product_images WHERE product_id = p.id LIMIT 1) AS image_url
+		FROM products AS p
+		INNER JOIN product_translations AS st ON p.id = st.product_id
+		INNER JOIN product_translations AS pt ON p.id = pt.product_id AND pt.lang = $3
+		INNER JOIN categories AS c ON p.category_id = c.id
+		WHERE p.status = 'published'
+			AND (' ' || st.search_title LIKE $2 OR $1 <% st.search_title)
+		GROUP BY p.id, pt.title, c.slug
+		ORDER BY bool_or(' ' || st.search_title LIKE $2) DESC,
+			MAX(word_similarity($1, st.search_title)) DESC
+		LIMIT $4;
+	`, query, prefix, lang, SUGGEST_PRODUCTS)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pgxscan.Select(db.Ctx, db.Client, &result.Categories, `
+		WITH category_titles AS (
+			SELECT c.id, lower(t.content) || ' ' || transliterate_ua(lower(t.content)) AS search_title
+			FROM categories AS c
+			INNER JOIN translations AS t ON c.title_translation_item = t.item_id
+		)
+		SELECT c.id, c.slug, t.content AS title
+		FROM categories AS c
+		INNER JOIN category_titles AS ct ON c.id = ct.id
+		INNER JOIN translations AS t ON c.title_translation_item = t.item_id AND t.lang = $3
+		WHERE ' ' || ct.search_title LIKE $2 OR $1 <% ct.search_title
+		GROUP BY c.id, t.content
+		ORDER BY bool_or(' ' || ct.search_title LIKE $2) DESC,
+			MAX(word_similarity($1, ct.search_title)) DESC
+		LIMIT $4;
+	`, query, prefix, lang, SUGGEST_CATEGORIES)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
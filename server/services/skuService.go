@@ -0,0 +1,301 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/yura4ka/vydelka/db"
+)
+
+var ErrSkuNotFound = errors.New("product variant not found")
+var ErrProductHasSkus = errors.New("stock of a product with variants is managed per variant")
+var ErrInvalidSkuOptions = errors.New("options must be variants of different filters of the product category")
+var ErrDuplicateSku = errors.New("product already has a variant with these options")
+
+type NewSku struct {
+	Code    string         `json:"code" validate:"required,max=64" mod:"trim"`
+	Price   *uint64        `json:"price" validate:"omitempty,min=1"`
+	Stock   int            `json:"stock" validate:"min=0"`
+	Options []string       `json:"options" validate:"required,min=1,unique,dive,uuid"`
+	Images  []ProductImage `json:"images" validate:"dive"`
+}
+
+// Sku is a sellable variant of a product. Options maps filter ids to the
+// variant ids that describe it, e.g. size -> M and color -> black.
+type Sku struct {
+	Id       string            `json:"id"`
+	Code     string            `json:"code"`
+	Price    uint64            `json:"price"`
+	OldPrice *uint64           `json:"oldPrice,omitempty"`
+	Stock    int               `json:"stock"`
+	Options  map[string]string `json:"options"`
+	Images   []ProductImage    `json:"images"`
+}
+
+func getSkus(condition string, arg any) ([]Sku, error) {
+	result := make([]Sku, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT ps.id, ps.code, COALESCE(ps.price, s.price, p.price) AS price,
+			CASE WHEN ps.price IS NULL AND s.price < p.price THEN p.price END AS old_price, ps.stock,
+			COALESCE((
+				SELECT json_object_agg(fv.filter_id, fv.id)
+				FROM sku_options AS so
+				INNER JOIN filter_variants AS fv ON so.variant_id = fv.id
+				WHERE so.sku_id = ps.id
+			), '{}') AS options,
+			COALESCE((
+				SELECT json_agg(jsonb_build_object(
+					'id', si.id, 'imageUrl', si.image_url, 'width', si.width, 'height', si.height
+				))
+				FROM sku_images AS si
+				WHERE si.sku_id = ps.id
+			), '[]') AS images
+		FROM product_skus AS ps
+		INNER JOIN products AS p ON ps.product_id = p.id
+		LEFT JOIN active_sales AS s ON p.id = s.product_id
+		WHERE `+condition+`
+		ORDER BY ps.created_at;
+	`, arg)
+	return result, err
+}
+
+func GetProductSkus(productId string) ([]Sku, error) {
+	return getSkus("ps.product_id = $1", productId)
+}
+
+func getSkusByIds(ids []string) ([]Sku, error) {
+	return getSkus("ps.id = ANY($1::uuid[])", ids)
+}
+
+func productHasSkus(productId string) (bool, error) {
+	var result bool
+	err := pgxscan.Get(db.Ctx, db.Client, &result, `
+		SELECT EXISTS(SELECT 1 FROM product_skus WHERE product_id = $1);
+	`, productId)
+	return result, err
+}
+
+func skuBelongsTo(productId, skuId string) (bool, error) {
+	var result bool
+	err := pgxscan.Get(db.Ctx, db.Client, &result, `
+		SELECT EXISTS(SELECT 1 FROM product_skus WHERE id = $1 AND product_id = $2);
+	`, skuId, productId)
+	return result, err
+}
+
+// matchSkuOptions checks the options against the filters of the variants
+// found in the product category: every option must be one of them and no
+// filter may be used twice. Ids come from the database in lower case.
+func matchSkuOptions(options []string, filters map[string]string) error {
+	used := make(map[string]bool)
+	for _, o := range options {
+		filter, ok := filters[strings.ToLower(o)]
+		if !ok || used[filter] {
+			return ErrInvalidSkuOptions
+		}
+		used[filter] = true
+	}
+	return nil
+}
+
+// checkSkuOptions makes sure every option is a variant of a filter available
+// to the product's category, that no filter is used twice and that no other
+// variant of the product has the same options. The product row is locked so
+// concurrent requests can't create the same combination.
+func checkSkuOptions(tx *pgx.Tx, productId string, skuId *string, options []string) error {
+	var categoryId string
+	err := pgxscan.Get(db.Ctx, *tx, &categoryId, `
+		SELECT category_id FROM products WHERE id = $1 FOR UPDATE;
+	`, productId)
+	if pgxscan.NotFound(err) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var variants []struct{ Id, FilterId string }
+	err = pgxscan.Select(db.Ctx, *tx, &variants, `
+		WITH RECURSIVE CategoryAncestors AS (
+			SELECT c.id, c.parent_id
+			FROM categories AS c
+			WHERE c.id = $1
+
+			UNION ALL
+
+			SELECT c.id, c.parent_id
+			FROM categories AS c
+			JOIN CategoryAncestors AS ca ON c.id = ca.parent_id
+		)
+		SELECT fv.id, fv.filter_id
+		FROM filter_variants AS fv
+		INNER JOIN filters AS f ON fv.filter_id = f.id
+		WHERE fv.id = ANY($2::uuid[]) AND f.category_id IN (SELECT id FROM CategoryAncestors);
+	`, categoryId, options)
+	if err != nil {
+		return err
+	}
+
+	filters := make(map[string]string, len(variants))
+	for _, v := range variants {
+		filters[v.Id] = v.FilterId
+	}
+	if err := matchSkuOptions(options, filters); err != nil {
+		return err
+	}
+
+	var exists bool
+	err = pgxscan.Get(db.Ctx, *tx, &exists, `
+		SELECT EXISTS(
+			SELECT 1 FROM product_skus AS ps
+			WHERE ps.product_id = $1 AND ps.id IS DISTINCT FROM $2::uuid
+				AND ARRAY(SELECT so.variant_id FROM sku_options AS so WHERE so.sku_id = ps.id ORDER BY 1)
+					= ARRAY(SELECT UNNEST($3::uuid[]) ORDER BY 1)
+		);
+	`, productId, skuId, options)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicateSku
+	}
+	return nil
+}
+
+func addSkuOptions(tx *pgx.Tx, id string, options []string) error {
+	_, err := (*tx).Exec(db.Ctx, `
+		INSERT INTO sku_options (sku_id, variant_id)
+		SELECT $1, UNNEST($2::uuid[])
+		ON CONFLICT DO NOTHING;
+	`, id, options)
+	return err
+}
+
+func addSkuImages(tx *pgx.Tx, id string, images []ProductImage) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO sku_images (sku_id, id, image_url, width, height) VALUES\n"
+	args := make([]any, 0, len(images)*4+1)
+	args = append(args, id)
+	values := make([]string, len(images))
+
+	argCount := 2
+	for i, v := range images {
+		values[i] = fmt.Sprintf("($1, $%d, $%d, $%d, $%d)", argCount, argCount+1, argCount+2, argCount+3)
+		args = append(args, v.Id, v.ImageUrl, v.Width, v.Height)
+		argCount += 4
+	}
+
+	_, err := (*tx).Exec(db.Ctx, query+strings.Join(values, ","), args...)
+	return err
+}
+
+func CreateSku(productId string, s *NewSku) (string, error) {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	if err := checkSkuOptions(&tx, productId, nil, s.Options); err != nil {
+		return "", err
+	}
+
+	var id string
+	err = pgxscan.Get(db.Ctx, tx, &id, `
+		INSERT INTO product_skus (product_id, code, price, stock)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+	`, productId, s.Code, s.Price, s.Stock)
+	if err != nil {
+		return "", err
+	}
+
+	if err := addSkuOptions(&tx, id, s.Options); err != nil {
+		return "", err
+	}
+
+	if err := addSkuImages(&tx, id, s.Images); err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit(db.Ctx)
+}
+
+type TChangeSku struct {
+	NewSku
+	Id string `json:"id" validate:"required,uuid" mod:"trim"`
+}
+
+func ChangeSku(productId string, s *TChangeSku) error {
+	tx, err := db.Client.Begin(db.Ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.Ctx)
+
+	if err := checkSkuOptions(&tx, productId, &s.Id, s.Options); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(db.Ctx, `
+		UPDATE product_skus
+		SET code = $1, price = $2, stock = $3
+		WHERE id = $4 AND product_id = $5;
+	`, s.Code, s.Price, s.Stock, s.Id, productId)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSkuNotFound
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		DELETE FROM sku_options WHERE sku_id = $1;
+	`, s.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(db.Ctx, `
+		DELETE FROM sku_images WHERE sku_id = $1;
+	`, s.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := addSkuOptions(&tx, s.Id, s.Options); err != nil {
+		return err
+	}
+
+	if err := addSkuImages(&tx, s.Id, s.Images); err != nil {
+		return err
+	}
+
+	return tx.Commit(db.Ctx)
+}
+
+func DeleteSku(productId, skuId string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		DELETE FROM product_skus WHERE id = $1 AND product_id = $2;
+	`, skuId, productId)
+	return err
+}
+
+func ChangeSkuStock(productId, skuId string, delta int) (int, error) {
+	var stock int
+	err := pgxscan.Get(db.Ctx, db.Client, &stock, `
+		UPDATE product_skus SET stock = stock + $1
+		WHERE id = $2 AND product_id = $3 AND stock + $1 >= 0
+		RETURNING stock;
+	`, delta, skuId, productId)
+	if pgxscan.NotFound(err) {
+		return 0, ErrOutOfStock
+	}
+	return stock, err
+}
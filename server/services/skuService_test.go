@@ -0,0 +1,37 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchSkuOptions(t *testing.T) {
+	filters := map[string]string{
+		"size-s": "size",
+		"size-m": "size",
+		"black":  "color",
+		"white":  "color",
+	}
+
+	tests := []struct {
+		name    string
+		options []string
+		want    error
+	}{
+		{"one filter", []string{"size-m"}, nil},
+		{"different filters", []string{"size-s", "black"}, nil},
+		{"upper case id", []string{"SIZE-S"}, nil},
+		{"variant of another category", []string{"size-s", "cotton"}, ErrInvalidSkuOptions},
+		{"same filter twice", []string{"size-s", "size-m"}, ErrInvalidSkuOptions},
+		{"same variant twice", []string{"black", "black"}, ErrInvalidSkuOptions},
+		{"nothing found", []string{"cotton"}, ErrInvalidSkuOptions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchSkuOptions(tt.options, filters); !errors.Is(got, tt.want) {
+				t.Errorf("matchSkuOptions(%v) = %v, want %v", tt.options, got, tt.want)
+			}
+		})
+	}
+}
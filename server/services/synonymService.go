@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/yura4ka/vydelka/db"
+)
+
+var ErrSynonymsNotFound = errors.New("synonyms not found")
+
+// Synonyms and stop words are read by the search_tsquery function on every
+// search, so changes apply immediately.
+
+type NewSynonyms struct {
+	Lang  Language `json:"lang" validate:"required,oneof=english ukrainian" mod:"trim"`
+	Terms []string `json:"terms" validate:"required,min=2,max=20,dive,required,max=64"`
+}
+
+type Synonyms struct {
+	Id        string     `json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	Version   int        `json:"version"`
+	NewSynonyms
+}
+
+func normalizeTerms(terms []string) []string {
+	result := make([]string, 0, len(terms))
+	for _, t := range terms {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !SliceContains(result, t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func GetSynonyms(lang Language) ([]Synonyms, error) {
+	result := make([]Synonyms, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT id, created_at, updated_at, version, lang, terms
+		FROM search_synonyms
+		WHERE $1 = '' OR lang::TEXT = $1
+		ORDER BY lang, terms;
+	`, lang)
+	return result, err
+}
+
+func CreateSynonyms(s *NewSynonyms) (string, error) {
+	var id string
+	err := pgxscan.Get(db.Ctx, db.Client, &id, `
+		INSERT INTO search_synonyms (lang, terms)
+		VALUES ($1, $2)
+		RETURNING id;
+	`, s.Lang, normalizeTerms(s.Terms))
+	return id, err
+}
+
+type TChangeSynonyms struct {
+	NewSynonyms
+	Id string `json:"id" validate:"required,uuid" mod:"trim"`
+}
+
+func ChangeSynonyms(s *TChangeSynonyms) error {
+	tag, err := db.Client.Exec(db.Ctx, `
+		UPDATE search_synonyms
+		SET lang = $1, terms = $2, version = version + 1
+		WHERE id = $3;
+	`, s.Lang, normalizeTerms(s.Terms), s.Id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSynonymsNotFound
+	}
+	return nil
+}
+
+func DeleteSynonyms(id string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		DELETE FROM search_synonyms WHERE id = $1;
+	`, id)
+	return err
+}
+
+type NewStopWord struct {
+	Lang Language `json:"lang" validate:"required,oneof=english ukrainian" mod:"trim"`
+	Word string   `json:"word" validate:"required,max=64" mod:"trim"`
+}
+
+type StopWord struct {
+	Id        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	NewStopWord
+}
+
+func GetStopWords(lang Language) ([]StopWord, error) {
+	result := make([]StopWord, 0)
+	err := pgxscan.Select(db.Ctx, db.Client, &result, `
+		SELECT id, created_at, lang, word
+		FROM search_stop_words
+		WHERE $1 = '' OR lang::TEXT = $1
+		ORDER BY lang, word;
+	`, lang)
+	return result, err
+}
+
+func CreateStopWord(w *NewStopWord) (string, error) {
+	var id string
+	err := pgxscan.Get(db.Ctx, db.Client, &id, `
+		INSERT INTO search_stop_words (lang, word)
+		VALUES ($1, $2)
+		RETURNING id;
+	`, w.Lang, strings.ToLower(w.Word))
+	return id, err
+}
+
+func DeleteStopWord(id string) error {
+	_, err := db.Client.Exec(db.Ctx, `
+		DELETE FROM search_stop_words WHERE id = $1;
+	`, id)
+	return err
+}
@@ -23,7 +23,8 @@ type (
 	}
 )
 
-var FORBIDDEN_FILTERS = []string{"categoryId", "withTranslations", "page", "orderBy", "ids", "q"}
+var FORBIDDEN_FILTERS = []string{"categoryId", "withTranslations", "page", "orderBy", "ids", "q", "status",
+	"minPrice", "maxPrice", "minRating", "withSubcategories", "limit", "cursor", "skipTotal"}
 
 var validate = validator.New()
 var conform = modifiers.New()
@@ -61,24 +62,26 @@ func ValidateJSON(c *fiber.Ctx, input interface{}) *fiber.Error {
 	}
 
 	if errs := Validate(input); len(errs) > 0 && errs[0].Error {
-		errMsgs := make([]string, 0)
-
-		for _, err := range errs {
-			errMsgs = append(errMsgs, fmt.Sprintf(
-				"[%s]: Needs to implement '%s'",
-				err.FailedField,
-				err.Tag,
-			))
-		}
-
 		return &fiber.Error{
 			Code:    fiber.ErrBadRequest.Code,
-			Message: strings.Join(errMsgs, " and "),
+			Message: strings.Join(ValidationMessages(errs), " and "),
 		}
 	}
 	return nil
 }
 
+func ValidationMessages(errs []ErrorResponse) []string {
+	errMsgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		errMsgs = append(errMsgs, fmt.Sprintf(
+			"[%s]: Needs to implement '%s'",
+			err.FailedField,
+			err.Tag,
+		))
+	}
+	return errMsgs
+}
+
 func ValidateVar(field interface{}, tags ...string) error {
 	if len(tags) < 1 {
 		return nil